@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The bash completion completes the global flags and their values, the
+// groups and the commands of a group. The flags of the commands belong to
+// each tool and are left to the default completion.
+const bashCompletion = `_automations() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+	local words=() i
+
+	case "$prev" in
+	--output) COMPREPLY=($(compgen -W "text json" -- "$cur")); return ;;
+	--log-level) COMPREPLY=($(compgen -W "info debug" -- "$cur")); return ;;
+	--profile|--region) return ;;
+	esac
+
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case "${COMP_WORDS[i]}" in
+		--profile|--region|--output|--log-level) ((i++)) ;;
+		-*) ;;
+		*) words+=("${COMP_WORDS[i]}") ;;
+		esac
+	done
+
+	case "${#words[@]}" in
+	0)
+		if [[ "$cur" == -* ]]; then
+			COMPREPLY=($(compgen -W "--profile --region --output --log-level --dry-run" -- "$cur"))
+		else
+			COMPREPLY=($(compgen -W "%s completion help" -- "$cur"))
+		fi
+		;;
+	1)
+		case "${words[0]}" in
+%s		completion) COMPREPLY=($(compgen -W "bash zsh" -- "$cur")) ;;
+		esac
+		;;
+	esac
+}
+`
+
+// Completion prints the completion script of a shell, zsh uses the bash
+// one through bashcompinit.
+func Completion(args []string, stdout, stderr io.Writer) int {
+
+	if len(args) != 1 {
+		return usageError(stderr, "completion needs a shell: bash or zsh")
+	}
+
+	var cases strings.Builder
+	for _, group := range Groups() {
+		var names []string
+		for _, c := range Commands {
+			if c.Group == group {
+				names = append(names, c.Name)
+			}
+		}
+		fmt.Fprintf(&cases, "\t\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", group, strings.Join(names, " "))
+	}
+	script := fmt.Sprintf(bashCompletion, strings.Join(Groups(), " "), cases.String())
+
+	switch args[0] {
+	case "bash":
+		fmt.Fprint(stdout, script)
+		fmt.Fprintln(stdout, "complete -o default -F _automations automations")
+	case "zsh":
+		fmt.Fprintln(stdout, "autoload -U +X bashcompinit && bashcompinit")
+		fmt.Fprint(stdout, script)
+		fmt.Fprintln(stdout, "complete -o default -F _automations automations")
+	default:
+		return usageError(stderr, "unknown shell "+args[0]+", use bash or zsh")
+	}
+
+	return 0
+}
@@ -0,0 +1,34 @@
+module automations
+
+go 1.18
+
+require (
+	awssession v0.0.0-00010101000000-000000000000
+	ce-cleaner v0.0.0-00010101000000-000000000000
+	db-checker v0.0.0-00010101000000-000000000000
+	db-migration-v2 v0.0.0-00010101000000-000000000000
+	db-upgrade v0.0.0-00010101000000-000000000000
+	github.com/aws/aws-sdk-go v1.44.122
+	link-checker v0.0.0-00010101000000-000000000000
+	volume-cleaner v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/aws/aws-lambda-go v1.32.0 // indirect
+	github.com/go-sql-driver/mysql v1.6.0 // indirect
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	go.etcd.io/bbolt v1.3.8 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+	golang.org/x/term v0.12.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace (
+	awssession => ../awssession
+	ce-cleaner => ../ce-cleaner
+	db-checker => ../db-checker
+	db-migration-v2 => ../db-migration
+	db-upgrade => ../db-upgrade
+	link-checker => ../link-checker
+	volume-cleaner => ../volume-cleaner
+)
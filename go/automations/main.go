@@ -0,0 +1,345 @@
+// Command automations runs every tool of this repository as a subcommand
+// of one binary, with the same global flags and exit codes:
+//
+//	0   success
+//	1   the command failed
+//	2   usage error: unknown command, invalid flag or missing parameter
+//	130 interrupted
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"awssession"
+	cecleaner "ce-cleaner"
+	dbchecker "db-checker"
+	dbmigration "db-migration-v2"
+	dbupgrade "db-upgrade"
+	linkchecker "link-checker"
+	volumecleaner "volume-cleaner"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	ExitFailure   = 1
+	ExitUsage     = 2
+	ExitInterrupt = 130
+)
+
+// Global holds the flags shared by every command, given before the
+// command name.
+type Global struct {
+	Profile  string
+	Region   string
+	Output   string
+	LogLevel string
+	DryRun   bool
+}
+
+// Command is a subcommand. Commands run their tool with the arguments
+// that follow their name, so each keeps its own flags and behavior. The
+// global flags are translated into the tool's own flags.
+type Command struct {
+	Group string
+	Name  string
+	Usage string
+	Run   func(args []string)
+
+	// ReadOnly commands never change anything, --dry-run is ignored
+	ReadOnly bool
+	// DryRun and JSON add the tool's flags for --dry-run and
+	// --output=json to the arguments, they are nil when the tool has none.
+	DryRun func(args []string) ([]string, error)
+	JSON   func(args []string) ([]string, error)
+	// Setup applies --profile and --region to tools that don't take them
+	// from the environment.
+	Setup func(g Global)
+	// Commands handling interrupts themselves, to clean up, exit with 130
+	// on their own.
+	HandlesInterrupt bool
+}
+
+func (c Command) Path() string {
+	return c.Group + " " + c.Name
+}
+
+var Commands = []Command{
+	{
+		Group:            "db",
+		Name:             "migrate",
+		Usage:            "Migrate an Aurora cluster to another account, or run its decommission, gc, serve and verify-audit commands",
+		Run:              dbmigration.Main,
+		DryRun:           migrationDryRun,
+		Setup:            migrationSetup,
+		HandlesInterrupt: true,
+	},
+	{
+		Group:    "db",
+		Name:     "check",
+		Usage:    "Check that a MySQL database accepts connections",
+		Run:      dbchecker.Main,
+		ReadOnly: true,
+	},
+	{
+		Group: "db",
+		Name:  "upgrade",
+		Usage: "Upgrade a database",
+		Run:   dbupgrade.Main,
+	},
+	{
+		Group:  "batch",
+		Name:   "clean",
+		Usage:  "Delete AWS Batch job queues and compute environments, or run the job-definitions, sweep and restore commands",
+		Run:    cecleaner.Main,
+		DryRun: batchDryRun,
+		JSON:   batchJSON,
+	},
+	{
+		Group:  "ebs",
+		Name:   "clean",
+		Usage:  "Delete the EBS volumes that aren't attached to any instance",
+		Run:    volumecleaner.Main,
+		DryRun: prepend("--dry-run"),
+	},
+	{
+		Group: "links",
+		Name:  "check",
+		Usage: "Check that the links given as arguments are up, notifying the ones that are down",
+		Run:   linkchecker.Main,
+	},
+}
+
+func main() {
+	os.Exit(Run(os.Args[1:], os.Stderr))
+}
+
+// Run runs the command of the arguments and returns the exit code of the
+// errors found before it starts. Commands exit on their own when they fail.
+func Run(args []string, stderr io.Writer) int {
+
+	var g Global
+
+	fs := flag.NewFlagSet("automations", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { PrintUsage(stderr, fs) }
+	fs.StringVar(&g.Profile, "profile", "", "The AWS profile to use, sets AWS_PROFILE")
+	fs.StringVar(&g.Region, "region", "", "The AWS region to use, sets AWS_REGION")
+	fs.StringVar(&g.Output, "output", "text", "The output format: text or json")
+	fs.StringVar(&g.LogLevel, "log-level", "info", "The log level: info, or debug to log every AWS request")
+	fs.BoolVar(&g.DryRun, "dry-run", false, "Print what the command would change, without changing anything")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return ExitUsage
+	}
+
+	args = fs.Args()
+	if len(args) == 0 {
+		PrintUsage(stderr, fs)
+		return ExitUsage
+	}
+
+	switch args[0] {
+	case "help":
+		PrintUsage(os.Stdout, fs)
+		return 0
+	case "completion":
+		return Completion(args[1:], os.Stdout, stderr)
+	}
+
+	if len(args) < 2 {
+		return usageError(stderr, "missing command for "+args[0])
+	}
+	c, ok := Find(args[0], args[1])
+	if !ok {
+		return usageError(stderr, "unknown command: "+args[0]+" "+args[1])
+	}
+
+	args, err := Apply(c, g, args[2:])
+	if err != nil {
+		return usageError(stderr, err.Error())
+	}
+
+	if !c.HandlesInterrupt {
+		HandleInterrupt()
+	}
+	c.Run(args)
+
+	return 0
+}
+
+// Apply translates the global flags into the environment and the flags of
+// the command.
+func Apply(c Command, g Global, args []string) ([]string, error) {
+
+	var err error
+
+	switch g.Output {
+	case "text":
+	case "json":
+		if c.JSON == nil {
+			return nil, errors.New(c.Path() + " has no JSON output")
+		}
+		if args, err = c.JSON(args); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("invalid --output " + g.Output + ", use text or json")
+	}
+
+	switch g.LogLevel {
+	case "info":
+	case "debug":
+		awssession.LogLevel = aws.LogDebug
+	default:
+		return nil, errors.New("invalid --log-level " + g.LogLevel + ", use info or debug")
+	}
+
+	if g.DryRun && !c.ReadOnly {
+		if c.DryRun == nil {
+			return nil, errors.New(c.Path() + " has no dry run")
+		}
+		if args, err = c.DryRun(args); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.Profile != "" {
+		os.Setenv("AWS_PROFILE", g.Profile)
+	}
+	if g.Region != "" {
+		os.Setenv("AWS_REGION", g.Region)
+	}
+	if c.Setup != nil {
+		c.Setup(g)
+	}
+
+	return args, nil
+}
+
+func Find(group, name string) (Command, bool) {
+	for _, c := range Commands {
+		if c.Group == group && c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// Groups returns the command groups in alphabetical order.
+func Groups() []string {
+
+	var groups []string
+	seen := map[string]bool{}
+
+	for _, c := range Commands {
+		if !seen[c.Group] {
+			seen[c.Group] = true
+			groups = append(groups, c.Group)
+		}
+	}
+	sort.Strings(groups)
+
+	return groups
+}
+
+// HandleInterrupt exits with 130 on SIGINT and SIGTERM.
+func HandleInterrupt() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		os.Exit(ExitInterrupt)
+	}()
+}
+
+func PrintUsage(w io.Writer, fs *flag.FlagSet) {
+
+	fmt.Fprintln(w, "Usage: automations [global flags] <group> <command> [command flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, group := range Groups() {
+		for _, c := range Commands {
+			if c.Group == group {
+				fmt.Fprintf(w, "  %-14s %s\n", c.Path(), c.Usage)
+			}
+		}
+	}
+	fmt.Fprintf(w, "  %-14s %s\n", "completion", "Print the bash or zsh completion script")
+	fmt.Fprintln(w, "\nGlobal flags:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "\nThe flags of a command are listed with: automations <group> <command> -h")
+}
+
+func usageError(w io.Writer, msg string) int {
+	fmt.Fprintln(w, "automations: "+msg)
+	fmt.Fprintln(w, "Run automations help for the list of commands.")
+	return ExitUsage
+}
+
+// prepend returns a DryRun or JSON function adding flags before the
+// arguments, where the flag package still parses them.
+func prepend(flags ...string) func(args []string) ([]string, error) {
+	return func(args []string) ([]string, error) {
+		return append(append([]string{}, flags...), args...), nil
+	}
+}
+
+// The commands of ce-cleaner come before its flags
+var batchCommands = map[string]bool{
+	"job-definitions": true,
+	"sweep":           true,
+	"restore":         true,
+}
+
+func batchDryRun(args []string) ([]string, error) {
+	if len(args) > 0 && batchCommands[args[0]] {
+		return append([]string{args[0], "--dry-run"}, args[1:]...), nil
+	}
+	return prepend("--dry-run")(args)
+}
+
+func batchJSON(args []string) ([]string, error) {
+	if len(args) > 0 && batchCommands[args[0]] {
+		return nil, errors.New("batch clean " + args[0] + " has no JSON output")
+	}
+	return prepend("--json")(args)
+}
+
+// Only gc can run dry, verify-audit doesn't change anything.
+func migrationDryRun(args []string) ([]string, error) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "gc":
+			return append([]string{"gc", "--DryRun"}, args[1:]...), nil
+		case "verify-audit":
+			return args, nil
+		}
+	}
+	return nil, errors.New("db migrate has no dry run, only db migrate gc has")
+}
+
+// The migration reaches two accounts, --profile and --region become the
+// defaults of both, the config file and the command flags still override
+// them. Runs started by db migrate serve run this binary.
+func migrationSetup(g Global) {
+
+	if g.Profile != "" {
+		dbmigration.SourceProfile = g.Profile
+		dbmigration.DestinationProfile = g.Profile
+	}
+	if g.Region != "" {
+		dbmigration.SourceProfileRegion = g.Region
+		dbmigration.DestinationProfileRegion = g.Region
+	}
+	dbmigration.ExecArgs = []string{"db", "migrate"}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchDryRun(t *testing.T) {
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"no arguments", nil, []string{"--dry-run"}},
+		{"cleanup flags", []string{"--include", "ci-*"}, []string{"--dry-run", "--include", "ci-*"}},
+		{"job-definitions", []string{"job-definitions", "--keep", "3"}, []string{"job-definitions", "--dry-run", "--keep", "3"}},
+		{"sweep", []string{"sweep", "--organization"}, []string{"sweep", "--dry-run", "--organization"}},
+		{"restore", []string{"restore", "--bundle", "b.json"}, []string{"restore", "--dry-run", "--bundle", "b.json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := batchDryRun(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batchDryRun(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchJSON(t *testing.T) {
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		err  bool
+	}{
+		{"cleanup", []string{"--include", "ci-*"}, []string{"--json", "--include", "ci-*"}, false},
+		{"sweep", []string{"sweep"}, nil, true},
+		{"job-definitions", []string{"job-definitions"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := batchJSON(tt.args)
+			if (err != nil) != tt.err {
+				t.Fatalf("batchJSON(%q) error = %v, want error %v", tt.args, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batchJSON(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationDryRun(t *testing.T) {
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		err  bool
+	}{
+		{"gc", []string{"gc", "--SourceProfile", "dev"}, []string{"gc", "--DryRun", "--SourceProfile", "dev"}, false},
+		{"verify-audit", []string{"verify-audit", "--AuditLog", "audit.log"}, []string{"verify-audit", "--AuditLog", "audit.log"}, false},
+		{"migration", []string{"--SourceClusterName", "gitea"}, nil, true},
+		{"decommission", []string{"decommission"}, nil, true},
+		{"no arguments", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := migrationDryRun(tt.args)
+			if (err != nil) != tt.err {
+				t.Fatalf("migrationDryRun(%q) error = %v, want error %v", tt.args, err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("migrationDryRun(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+
+	ebs, _ := Find("ebs", "clean")
+	check, _ := Find("db", "check")
+	links, _ := Find("links", "check")
+
+	tests := []struct {
+		name    string
+		command Command
+		global  Global
+		args    []string
+		want    []string
+		err     bool
+	}{
+		{"defaults", ebs, Global{Output: "text", LogLevel: "info"}, []string{"--x"}, []string{"--x"}, false},
+		{"dry run", ebs, Global{Output: "text", LogLevel: "info", DryRun: true}, nil, []string{"--dry-run"}, false},
+		{"read only ignores the dry run", check, Global{Output: "text", LogLevel: "info", DryRun: true}, nil, nil, false},
+		{"no dry run", links, Global{Output: "text", LogLevel: "info", DryRun: true}, nil, nil, true},
+		{"no JSON output", ebs, Global{Output: "json", LogLevel: "info"}, nil, nil, true},
+		{"invalid output", ebs, Global{Output: "yaml", LogLevel: "info"}, nil, nil, true},
+		{"invalid log level", ebs, Global{Output: "text", LogLevel: "trace"}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply(tt.command, tt.global, tt.args)
+			if (err != nil) != tt.err {
+				t.Fatalf("Apply() error = %v, want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
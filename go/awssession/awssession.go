@@ -0,0 +1,180 @@
+// Package awssession creates the AWS sessions of every tool the same way:
+// from a shared config profile and region, optionally assuming a role with
+// an external ID and an MFA token, and against a custom endpoint when one
+// is set, so a tool can run against a local AWS stand-in.
+package awssession
+
+import (
+	"errors"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// EndpointEnv is the environment variable setting the endpoint when the
+// config has none, e.g. http://localhost:4566.
+const EndpointEnv = "AWS_ENDPOINT_URL"
+
+// LogLevel is the SDK log level of every new session, e.g. aws.LogDebug
+// to log each request. Sessions don't log by default.
+var LogLevel aws.LogLevelType
+
+// Config selects the credentials, the region and the endpoint of a
+// session. Empty fields fall back to the SDK defaults: the AWS_PROFILE
+// environment variable or the default profile, and the AWS_REGION or
+// AWS_DEFAULT_REGION environment variables or the region of the profile.
+type Config struct {
+	Profile string
+	Region  string
+
+	// A role to assume with the credentials of the profile
+	RoleArn     string
+	ExternalID  string
+	SessionName string
+
+	// With an MFA device the token is asked for on the terminal unless it
+	// is given. Without a role, temporary credentials of the profile's
+	// user are requested with it.
+	MFASerial string
+	MFAToken  string
+
+	Endpoint string
+}
+
+// New creates a session and fails when no region can be found.
+func New(c Config) (*session.Session, error) {
+
+	opts := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Profile:           c.Profile,
+		// Profiles with an mfa_serial ask for the token too
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	}
+	if c.Region != "" {
+		opts.Config.Region = aws.String(c.Region)
+	}
+	if LogLevel != aws.LogOff {
+		opts.Config.LogLevel = aws.LogLevel(LogLevel)
+	}
+	if endpoint := Endpoint(c); endpoint != "" {
+		opts.Config.Endpoint = aws.String(endpoint)
+		// Stand-ins don't serve buckets as subdomains
+		opts.Config.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	region, err := ResolveRegion(c, sess)
+	if err != nil {
+		return nil, err
+	}
+	sess.Config.Region = aws.String(region)
+
+	switch {
+	case c.RoleArn != "":
+		sess = sess.Copy(&aws.Config{
+			Credentials: stscreds.NewCredentials(sess, c.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+				if c.ExternalID != "" {
+					p.ExternalID = aws.String(c.ExternalID)
+				}
+				if c.SessionName != "" {
+					p.RoleSessionName = c.SessionName
+				}
+				if c.MFASerial != "" {
+					p.SerialNumber = aws.String(c.MFASerial)
+					p.TokenProvider = tokenProvider(c)
+				}
+			}),
+		})
+	case c.MFASerial != "":
+		creds, err := sessionToken(c, sess)
+		if err != nil {
+			return nil, err
+		}
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+
+	return sess, nil
+}
+
+// Must is like New but panics on errors, like session.Must.
+func Must(c Config) *session.Session {
+	sess, err := New(c)
+	if err != nil {
+		panic(err)
+	}
+	return sess
+}
+
+// ResolveRegion returns the region of the config, or else the one of the
+// environment or of the profile the session was created from.
+func ResolveRegion(c Config, sess *session.Session) (string, error) {
+
+	if c.Region != "" {
+		return c.Region, nil
+	}
+	if r := aws.StringValue(sess.Config.Region); r != "" {
+		return r, nil
+	}
+	if r := os.Getenv("AWS_DEFAULT_REGION"); r != "" {
+		return r, nil
+	}
+
+	profile := c.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	return "", errors.New("no region: give one, set AWS_REGION or add a region to the " + profile + " profile in ~/.aws/config")
+}
+
+// Endpoint returns the endpoint of the config, or else the one of the
+// environment.
+func Endpoint(c Config) string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	return os.Getenv(EndpointEnv)
+}
+
+func tokenProvider(c Config) func() (string, error) {
+	if c.MFAToken == "" {
+		return stscreds.StdinTokenProvider
+	}
+	return func() (string, error) { return c.MFAToken, nil }
+}
+
+// sessionToken exchanges the credentials of the session and an MFA token
+// for temporary credentials.
+func sessionToken(c Config, sess *session.Session) (*credentials.Credentials, error) {
+
+	token, err := tokenProvider(c)()
+	if err != nil {
+		return nil, err
+	}
+
+	svc := sts.New(sess)
+	result, err := svc.GetSessionToken(&sts.GetSessionTokenInput{
+		SerialNumber: aws.String(c.MFASerial),
+		TokenCode:    aws.String(token),
+	})
+	if err != nil {
+		return nil, errors.New("MFA session token: " + err.Error())
+	}
+
+	return credentials.NewStaticCredentials(
+		aws.StringValue(result.Credentials.AccessKeyId),
+		aws.StringValue(result.Credentials.SecretAccessKey),
+		aws.StringValue(result.Credentials.SessionToken),
+	), nil
+}
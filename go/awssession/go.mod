@@ -0,0 +1,7 @@
+module awssession
+
+go 1.17
+
+require github.com/aws/aws-sdk-go v1.44.0
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
@@ -0,0 +1,495 @@
+package cecleaner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Bundle is the configuration of the resources of a cleanup, written
+// before anything is deleted so that the restore command can recreate
+// them. The Batch resources are kept as Batch describes them.
+type Bundle struct {
+	Created          time.Time                         `json:"created"`
+	Account          string                            `json:"account"`
+	Region           string                            `json:"region"`
+	Queues           []*batch.JobQueueDetail           `json:"queues,omitempty"`
+	Environments     []*batch.ComputeEnvironmentDetail `json:"compute_environments,omitempty"`
+	JobDefinitions   []*batch.JobDefinition            `json:"job_definitions,omitempty"`
+	Roles            []*RoleBackup                     `json:"roles,omitempty"`
+	InstanceProfiles []*InstanceProfileBackup          `json:"instance_profiles,omitempty"`
+	LaunchTemplates  []*LaunchTemplateBackup           `json:"launch_templates,omitempty"`
+}
+
+// RoleBackup is an IAM role with its trust policy and policies. The
+// documents are decoded JSON.
+type RoleBackup struct {
+	Name             string            `json:"name"`
+	Path             string            `json:"path"`
+	Description      string            `json:"description,omitempty"`
+	TrustPolicy      string            `json:"trust_policy"`
+	AttachedPolicies []*PolicyBackup   `json:"attached_policies,omitempty"`
+	InlinePolicies   map[string]string `json:"inline_policies,omitempty"`
+}
+
+// PolicyBackup is a managed policy attached to a role. Only the customer
+// managed ones have a document, the AWS managed ones exist everywhere.
+type PolicyBackup struct {
+	Arn      string `json:"arn"`
+	Name     string `json:"name"`
+	Path     string `json:"path,omitempty"`
+	Document string `json:"document,omitempty"`
+}
+
+type InstanceProfileBackup struct {
+	Name  string   `json:"name"`
+	Path  string   `json:"path"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// LaunchTemplateBackup is a launch template with the version its compute
+// environments use, which is what a restore recreates as the only version.
+type LaunchTemplateBackup struct {
+	ID      string                          `json:"id"`
+	Name    string                          `json:"name"`
+	Version int64                           `json:"version"`
+	Tags    map[string]string               `json:"tags,omitempty"`
+	Data    *ec2.ResponseLaunchTemplateData `json:"data"`
+}
+
+// DefaultBackup is where the bundle is written unless told otherwise.
+const DefaultBackup = "ce-cleaner-backup-{account}-{region}-{time}.json"
+
+// NewBundle backs up what a plan deletes: the job queues, the compute
+// environments, their service roles, instance profiles and launch
+// templates, with the roles of the instance profiles.
+func NewBundle(plan *Plan, sess *session.Session) (*Bundle, error) {
+
+	b, err := newBundle(sess)
+	if err != nil {
+		return nil, err
+	}
+	b.Queues = plan.Queues
+	b.Environments = plan.Environments
+
+	for _, p := range plan.InstanceProfiles {
+		name, err := InstanceProfileName(p)
+		if err != nil {
+			return nil, err
+		}
+		result, err := GetInstanceProfile(name, sess)
+		if err != nil {
+			return nil, err
+		}
+		ip := &InstanceProfileBackup{
+			Name: name,
+			Path: aws.StringValue(result.InstanceProfile.Path),
+		}
+		for _, r := range result.InstanceProfile.Roles {
+			ip.Roles = append(ip.Roles, *r.RoleName)
+			if err := b.addRole(*r.RoleName, sess); err != nil {
+				return nil, err
+			}
+		}
+		b.InstanceProfiles = append(b.InstanceProfiles, ip)
+	}
+
+	for _, r := range plan.Roles {
+		name, err := RoleName(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.addRole(name, sess); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, lt := range plan.LaunchTemplates {
+		version := "$Default"
+		for _, e := range plan.Environments {
+			if launchTemplate(e) == lt && e.ComputeResources.LaunchTemplate.Version != nil {
+				version = *e.ComputeResources.LaunchTemplate.Version
+			}
+		}
+		t, err := GetLaunchTemplate(lt, sess)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			continue
+		}
+		v, err := GetLaunchTemplateVersion(*t.LaunchTemplateId, version, sess)
+		if err != nil {
+			return nil, err
+		}
+		ltb := &LaunchTemplateBackup{
+			ID:      *t.LaunchTemplateId,
+			Name:    aws.StringValue(t.LaunchTemplateName),
+			Version: aws.Int64Value(v.VersionNumber),
+			Tags:    map[string]string{},
+			Data:    v.LaunchTemplateData,
+		}
+		for _, tag := range t.Tags {
+			ltb.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		b.LaunchTemplates = append(b.LaunchTemplates, ltb)
+	}
+
+	return b, nil
+}
+
+// NewJobDefinitionBundle backs up job definition revisions.
+func NewJobDefinitionBundle(arns []string, sess *session.Session) (*Bundle, error) {
+
+	b, err := newBundle(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	b.JobDefinitions, err = GetJobDefinitions(arns, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func newBundle(sess *session.Session) (*Bundle, error) {
+
+	id, err := CallerIdentity(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bundle{
+		Created: time.Now().UTC(),
+		Account: id.AccountID,
+		Region:  aws.StringValue(sess.Config.Region),
+	}, nil
+}
+
+func (b *Bundle) addRole(name string, sess *session.Session) error {
+
+	for _, r := range b.Roles {
+		if r.Name == name {
+			return nil
+		}
+	}
+
+	result, err := GetRole(name, sess)
+	if err != nil {
+		return err
+	}
+	trust, err := url.QueryUnescape(aws.StringValue(result.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return err
+	}
+	r := &RoleBackup{
+		Name:           name,
+		Path:           aws.StringValue(result.Role.Path),
+		Description:    aws.StringValue(result.Role.Description),
+		TrustPolicy:    trust,
+		InlinePolicies: map[string]string{},
+	}
+
+	attached, err := ListAttachedRolePolicies(name, sess)
+	if err != nil {
+		return err
+	}
+	for _, p := range attached {
+		policy := &PolicyBackup{
+			Arn:  *p.PolicyArn,
+			Name: aws.StringValue(p.PolicyName),
+		}
+		if a, err := arn.Parse(*p.PolicyArn); err == nil && a.AccountID != "aws" {
+			if policy.Path, policy.Document, err = GetPolicyDocument(*p.PolicyArn, sess); err != nil {
+				return err
+			}
+		}
+		r.AttachedPolicies = append(r.AttachedPolicies, policy)
+	}
+
+	inline, err := ListRolePolicies(name, sess)
+	if err != nil {
+		return err
+	}
+	for _, p := range inline {
+		if r.InlinePolicies[p], err = GetRolePolicy(name, p, sess); err != nil {
+			return err
+		}
+	}
+
+	b.Roles = append(b.Roles, r)
+
+	return nil
+}
+
+// BackupPath fills in the {account} and {region} placeholders of a backup
+// destination, so that a sweep writes one bundle per account and region,
+// and of {time}, the UTC time of the backup.
+func BackupPath(dest, account, region string) string {
+	now := time.Now().UTC().Format("20060102T150405Z")
+	return strings.NewReplacer("{account}", account, "{region}", region, "{time}", now).Replace(dest)
+}
+
+// WriteBundle writes a bundle to a file, or to S3 when the destination is
+// an s3://bucket/key URL.
+func WriteBundle(b *Bundle, dest string, sess *session.Session) error {
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if bucket, key, ok := s3Location(dest); ok {
+		_, err := PutObject(bucket, key, data, sess)
+		return err
+	}
+
+	return os.WriteFile(dest, data, 0600)
+}
+
+// ReadBundle reads a bundle written by WriteBundle.
+func ReadBundle(src string, sess *session.Session) (*Bundle, error) {
+
+	var (
+		data []byte
+		err  error
+	)
+
+	if bucket, key, ok := s3Location(src); ok {
+		data, err = GetObject(bucket, key, sess)
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, errors.New(src + " is not a backup bundle: " + err.Error())
+	}
+
+	return &b, nil
+}
+
+func s3Location(s string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(s, "s3://") {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(s, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func GetRolePolicy(r, p string, sess *session.Session) (string, error) {
+
+	svc := iam.New(sess)
+	input := &iam.GetRolePolicyInput{
+		RoleName:   aws.String(r),
+		PolicyName: aws.String(p),
+	}
+
+	result, err := svc.GetRolePolicy(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return "", errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return "", errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return "", errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return "", errors.New(err.Error())
+		}
+	}
+
+	return url.QueryUnescape(aws.StringValue(result.PolicyDocument))
+}
+
+// GetPolicyDocument returns the path and the default version of a managed
+// policy.
+func GetPolicyDocument(p string, sess *session.Session) (string, string, error) {
+
+	svc := iam.New(sess)
+
+	policy, err := svc.GetPolicy(&iam.GetPolicyInput{
+		PolicyArn: aws.String(p),
+	})
+	if err == nil {
+		var version *iam.GetPolicyVersionOutput
+		version, err = svc.GetPolicyVersion(&iam.GetPolicyVersionInput{
+			PolicyArn: aws.String(p),
+			VersionId: policy.Policy.DefaultVersionId,
+		})
+		if err == nil {
+			doc, err := url.QueryUnescape(aws.StringValue(version.PolicyVersion.Document))
+			return aws.StringValue(policy.Policy.Path), doc, err
+		}
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case iam.ErrCodeNoSuchEntityException:
+			return "", "", errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+		case iam.ErrCodeInvalidInputException:
+			return "", "", errors.New(iam.ErrCodeInvalidInputException + aerr.Error())
+		case iam.ErrCodeServiceFailureException:
+			return "", "", errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+		default:
+			return "", "", errors.New(aerr.Error())
+		}
+	} else {
+		// Print the error, cast err to awserr.Error to get the Code and
+		// Message from an error.
+		return "", "", errors.New(err.Error())
+	}
+}
+
+// GetLaunchTemplateVersion returns a version of a launch template, a
+// number, $Default or $Latest.
+func GetLaunchTemplateVersion(id, version string, sess *session.Session) (*ec2.LaunchTemplateVersion, error) {
+
+	svc := ec2.New(sess)
+	input := &ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId: aws.String(id),
+		Versions:         []*string{aws.String(version)},
+	}
+
+	result, err := svc.DescribeLaunchTemplateVersions(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return nil, errors.New(aerr.Code() + aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return nil, errors.New(err.Error())
+		}
+	}
+	if len(result.LaunchTemplateVersions) == 0 {
+		return nil, errors.New("launch template " + id + " has no version " + version)
+	}
+
+	return result.LaunchTemplateVersions[0], nil
+}
+
+// GetJobDefinitions describes job definition revisions by ARN, a hundred
+// at a time.
+func GetJobDefinitions(arns []string, sess *session.Session) ([]*batch.JobDefinition, error) {
+
+	var result []*batch.JobDefinition
+
+	svc := batch.New(sess)
+
+	for start := 0; start < len(arns); start += 100 {
+		end := start + 100
+		if end > len(arns) {
+			end = len(arns)
+		}
+		input := &batch.DescribeJobDefinitionsInput{
+			JobDefinitions: aws.StringSlice(arns[start:end]),
+		}
+
+		err := svc.DescribeJobDefinitionsPages(input, func(page *batch.DescribeJobDefinitionsOutput, last bool) bool {
+			result = append(result, page.JobDefinitions...)
+			return true
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				switch aerr.Code() {
+				case batch.ErrCodeClientException:
+					return result, errors.New(batch.ErrCodeClientException + aerr.Error())
+				case batch.ErrCodeServerException:
+					return result, errors.New(batch.ErrCodeServerException + aerr.Error())
+				default:
+					return result, errors.New(aerr.Error())
+				}
+			} else {
+				// Print the error, cast err to awserr.Error to get the Code and
+				// Message from an error.
+				return result, errors.New(err.Error())
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func PutObject(bucket, key string, data []byte, sess *session.Session) (*s3.PutObjectOutput, error) {
+
+	var result *s3.PutObjectOutput
+
+	svc := s3.New(sess)
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+
+	result, err := svc.PutObject(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchBucket:
+				return result, errors.New(s3.ErrCodeNoSuchBucket + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func GetObject(bucket, key string, sess *session.Session) ([]byte, error) {
+
+	svc := s3.New(sess)
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+
+	result, err := svc.GetObject(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey:
+				return nil, errors.New(s3.ErrCodeNoSuchKey + aerr.Error())
+			default:
+				return nil, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return nil, errors.New(err.Error())
+		}
+	}
+	defer result.Body.Close()
+
+	return io.ReadAll(result.Body)
+}
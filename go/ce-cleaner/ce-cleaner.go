@@ -1,10 +1,16 @@
-package main
+// Package cecleaner disables and deletes AWS Batch job queues, their
+// compute environments and what those leave behind. It runs from
+// cmd/ce-cleaner, as a Lambda function, and as the batch clean command of
+// automations.
+package cecleaner
 
 import (
 	"errors"
+	"flag"
 	"log"
-	"strings"
+	"os"
 
+	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -12,117 +18,97 @@ import (
 	"github.com/aws/aws-sdk-go/service/iam"
 )
 
-func main() {
+// Main runs the cleanup with the command line arguments, or one of the
+// job-definitions, sweep and restore commands.
+func Main(args []string) {
 
 	var (
-		roles []string
-		// launchTemplates []string
+		opts   Options
+		asJSON bool
 	)
 
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Profile:           "development",
-	}))
-
-	jq, err := GetJobQueue("", sess)
-	if err != nil {
-		log.Println(err)
+	if InLambda() {
+		lambda.Start(Handler)
 		return
 	}
 
-	for _, i := range jq.JobQueues {
-		result, err := GetJobQueue(*i.JobQueueName, sess)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		if *result.JobQueues[0].JobQueueName == "INVALID" {
-			log.Println("Job Queue", *i.JobQueueName, "is in invalid state.")
-			continue
-		}
-		log.Println("Disabling JobQueue:", *i.JobQueueName)
-		_, err = DisableJobQueue(*i.JobQueueName, sess)
-		if err != nil {
-			log.Println(err)
+	if len(args) > 0 {
+		switch args[0] {
+		case "job-definitions":
+			JobDefinitions(args[1:])
+			return
+		case "sweep":
+			Sweep(args[1:])
+			return
+		case "restore":
+			Restore(args[1:])
 			return
-		}
-		log.Println("Waiting for Job Queue", *i.JobQueueName, "to be disabled")
-		for status := false; !status; {
-			result, err = GetJobQueue(*i.JobQueueName, sess)
-			if *result.JobQueues[0].Status == "VALID" {
-				status = true
-			}
 		}
 	}
 
-	for _, i := range jq.JobQueues {
-		log.Println("Deleting JobQueue:", *i.JobQueueName)
-		_, err := DeleteJobQueue(*i.JobQueueName, sess)
-		if err != nil {
-			log.Println(err)
-			return
-		}
+	fs := flag.NewFlagSet("ce-cleaner", flag.ExitOnError)
+	SessionFlags(fs)
+	opts.Register(fs)
+	fs.BoolVar(&asJSON, "json", false, "Print the result as JSON, as the Lambda function returns it")
+	fs.Parse(args)
 
+	if err := ApplyEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+	if err := opts.Validate(); err != nil {
+		log.Fatal(err)
 	}
 
-	ce, err := GetComputeEnvironment("", sess)
+	sess := NewSession()
+
+	plan, err := opts.Plan(sess)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
 	}
 
-	for _, i := range ce.ComputeEnvironments {
-		result, err := GetComputeEnvironment(*i.ComputeEnvironmentName, sess)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		if *result.ComputeEnvironments[0].Status == "INVALID" {
-			log.Println("ComputeEnvironment:", *i.ComputeEnvironmentName, "is in invalid state")
-			continue
-		}
-		log.Println("Disabling ComputeEnvironment:", *i.ComputeEnvironmentName)
-		_, err = DisableComputeEnvironment(*i.ComputeEnvironmentName, sess)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		log.Println("Waiting for ComputeEnvironment:", *i.ComputeEnvironmentName, "to be disabled...")
-		for status := false; !status; {
-			result, err = GetComputeEnvironment(*i.ComputeEnvironmentName, sess)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
-			if *result.ComputeEnvironments[0].Status == "VALID" {
-				status = true
-			}
+	if opts.DryRun {
+		if asJSON {
+			PrintJSON(NewResult(aws.StringValue(sess.Config.Region), plan, nil))
+			return
 		}
+		plan.Print(os.Stdout)
+		return
 	}
 
-	for _, i := range ce.ComputeEnvironments {
-		result, err := GetComputeEnvironment(*i.ComputeEnvironmentName, sess)
-		if *result.ComputeEnvironments[0].Status == "INVALID" {
-			continue
-		}
-		log.Println("Deleting ComputeEnvironment:", *i.ComputeEnvironmentName)
-		_, err = DeleteComputeEnvironment(*i.ComputeEnvironmentName, sess)
-		if err != nil {
-			log.Println(err)
-			continue
+	if !plan.Empty() && !Yes {
+		plan.Print(os.Stdout)
+		if !Confirm(os.Stdin, os.Stdout, "\nThe resources above will be deleted.") {
+			log.Fatal("Aborted")
 		}
-
-		roles = append(roles, strings.Split(*i.ServiceRole, "/")[1])
 	}
 
-	for _, r := range roles {
-		log.Println("Deleting service role:", r)
-		_, err := DeleteRole(r, sess)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
+	c := opts.Run(plan, sess)
+	if asJSON {
+		PrintJSON(NewResult(aws.StringValue(sess.Config.Region), plan, c))
+	}
+	if err := c.Err(); err != nil {
+		log.Fatal(err)
 	}
+}
+
+func disableJobQueue(jq string, sess *session.Session) error {
+	_, err := DisableJobQueue(jq, sess)
+	return err
+}
+
+func deleteJobQueue(jq string, sess *session.Session) error {
+	_, err := DeleteJobQueue(jq, sess)
+	return err
+}
+
+func disableComputeEnvironment(ce string, sess *session.Session) error {
+	_, err := DisableComputeEnvironment(ce, sess)
+	return err
+}
 
+func deleteComputeEnvironment(ce string, sess *session.Session) error {
+	_, err := DeleteComputeEnvironment(ce, sess)
+	return err
 }
 
 func GetComputeEnvironment(ce string, sess *session.Session) (*batch.DescribeComputeEnvironmentsOutput, error) {
@@ -321,36 +307,6 @@ func DeleteComputeEnvironment(ce string, sess *session.Session) (*batch.DeleteCo
 	return result, nil
 }
 
-func GetJobDefinition(sess *session.Session) (*batch.DescribeJobDefinitionsOutput, error) {
-
-	var result *batch.DescribeJobDefinitionsOutput
-
-	svc := batch.New(sess)
-	// input := &batch.DescribeJobDefinitionsInput{
-	// 	Status: aws.String("ACTIVE"),
-	// }
-
-	result, err := svc.DescribeJobDefinitions(nil)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case batch.ErrCodeClientException:
-				return result, errors.New(batch.ErrCodeClientException + aerr.Error())
-			case batch.ErrCodeServerException:
-				return result, errors.New(batch.ErrCodeServerException + aerr.Error())
-			default:
-				return result, errors.New(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			return result, errors.New(err.Error())
-		}
-	}
-
-	return result, nil
-}
-
 func GetRole(r string, sess *session.Session) (*iam.GetRoleOutput, error) {
 
 	var result *iam.GetRoleOutput
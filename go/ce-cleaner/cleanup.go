@@ -0,0 +1,247 @@
+package cecleaner
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+)
+
+// Concurrency is how many resources are disabled, waited for or deleted at
+// the same time.
+var Concurrency = 8
+
+// Cleanup carries out a plan. Each stage runs on all its resources in
+// parallel and the next one starts when it is done: job queues are
+// disabled, drained and deleted, then the compute environments they used,
+// then what those leave behind. A resource that fails is kept with
+// everything depending on it, and the failures are reported together.
+type Cleanup struct {
+	Plan *Plan
+	Jobs JobOptions
+
+	// ARNs of the job queues and compute environments that are gone
+	Deleted map[string]bool
+	// Jobs that were cancelled or terminated
+	Stopped []*batch.JobSummary
+	Errors  []error
+
+	sess *session.Session
+	mu   sync.Mutex
+}
+
+func NewCleanup(plan *Plan, jobs JobOptions, sess *session.Session) *Cleanup {
+	return &Cleanup{
+		Plan:    plan,
+		Jobs:    jobs,
+		Deleted: map[string]bool{},
+		sess:    sess,
+	}
+}
+
+// Run goes through the stages and returns the failures as one error.
+func (c *Cleanup) Run() error {
+
+	p := c.Plan
+
+	disabled := map[string]bool{}
+	c.each(len(p.Queues), func(i int) error {
+		q := *p.Queues[i].JobQueueName
+		if err := DisableAndWait("JobQueue", q, JobQueueState, disableJobQueue, c.sess); err != nil {
+			return err
+		}
+		c.mark(disabled, q)
+		return nil
+	})
+
+	// Jobs still queued or running would make the deletion fail
+	drained := map[string]bool{}
+	c.each(len(p.Queues), func(i int) error {
+		q := *p.Queues[i].JobQueueName
+		if !disabled[q] {
+			return nil
+		}
+		found, err := DrainQueue(q, c.Jobs, c.sess)
+		if c.Jobs.Mode == JobsCancel {
+			c.mu.Lock()
+			c.Stopped = append(c.Stopped, found...)
+			c.mu.Unlock()
+		}
+		if err != nil {
+			return err
+		}
+		c.mark(drained, q)
+		return nil
+	})
+	for _, j := range c.Stopped {
+		log.Println("Stopped job:", *j.JobId, "("+aws.StringValue(j.JobName)+")", "was", aws.StringValue(j.Status))
+	}
+
+	// A compute environment can only be deleted once no queue refers to
+	// it, which is when the queue is gone rather than DELETING
+	c.each(len(p.Queues), func(i int) error {
+		q := p.Queues[i]
+		if !drained[*q.JobQueueName] {
+			log.Println("Skipping JobQueue:", *q.JobQueueName, "(not disabled or unfinished jobs)")
+			return nil
+		}
+		if err := DeleteAndWait("JobQueue", *q.JobQueueName, JobQueueState, deleteJobQueue, c.sess); err != nil {
+			return err
+		}
+		c.mark(c.Deleted, *q.JobQueueArn)
+		return nil
+	})
+
+	c.each(len(p.Environments), func(i int) error {
+		e := p.Environments[i]
+		if !c.queuesDeleted(p.queuesUsing(*e.ComputeEnvironmentArn)) {
+			log.Println("Skipping ComputeEnvironment:", *e.ComputeEnvironmentName, "(JobQueue not deleted)")
+			return nil
+		}
+		log.Println("ComputeEnvironment", *e.ComputeEnvironmentName, "is", EnvironmentType(e))
+		if err := DisableAndWait("ComputeEnvironment", *e.ComputeEnvironmentName, ComputeEnvironmentState, disableComputeEnvironment, c.sess); err != nil {
+			return err
+		}
+		if err := DeleteAndWait("ComputeEnvironment", *e.ComputeEnvironmentName, ComputeEnvironmentState, deleteComputeEnvironment, c.sess); err != nil {
+			return err
+		}
+		c.mark(c.Deleted, *e.ComputeEnvironmentArn)
+		return nil
+	})
+
+	// What Batch leaves behind can only go once the compute environment
+	// is gone
+	c.each(len(p.Environments), func(i int) error {
+		e := p.Environments[i]
+		if !c.isDeleted(*e.ComputeEnvironmentArn) {
+			return nil
+		}
+		var errs []string
+		for _, g := range p.AutoScalingGroups[*e.ComputeEnvironmentName] {
+			log.Println("Deleting Auto Scaling group:", g)
+			if _, err := DeleteAutoScalingGroup(g, c.sess); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if e.EcsClusterArn != nil {
+			if err := RemoveEcsCluster(*e.EcsClusterArn, c.sess); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, "; "))
+		}
+		return nil
+	})
+
+	c.each(len(p.LaunchTemplates), func(i int) error {
+		lt := p.LaunchTemplates[i]
+		if !c.environmentsDeleted(lt, launchTemplate) {
+			log.Println("Skipping launch template:", lt, "(ComputeEnvironment not deleted)")
+			return nil
+		}
+		return RemoveLaunchTemplate(lt, c.sess)
+	})
+
+	c.each(len(p.InstanceProfiles), func(i int) error {
+		ip := p.InstanceProfiles[i]
+		if !c.environmentsDeleted(ip, instanceProfile) {
+			log.Println("Skipping instance profile:", ip, "(ComputeEnvironment not deleted)")
+			return nil
+		}
+		return RemoveInstanceProfile(ip, c.sess)
+	})
+
+	// Batch needs the service role to tear down a compute environment
+	c.each(len(p.Roles), func(i int) error {
+		r := p.Roles[i]
+		if !c.environmentsDeleted(r, serviceRole) {
+			log.Println("Skipping service role:", r, "(ComputeEnvironment not deleted)")
+			return nil
+		}
+		return RemoveRole(r, c.sess)
+	})
+
+	return c.Err()
+}
+
+// Err returns the failures of the run as one error, nil if there were none.
+func (c *Cleanup) Err() error {
+
+	if len(c.Errors) == 0 {
+		return nil
+	}
+
+	var msgs []string
+	for _, err := range c.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	return errors.New(strconv.Itoa(len(c.Errors)) + " failures:\n  " + strings.Join(msgs, "\n  "))
+}
+
+// each calls fn for 0 to n-1 with at most Concurrency calls at a time and
+// records the errors.
+func (c *Cleanup) each(n int, fn func(i int) error) {
+
+	limit := Concurrency
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(i); err != nil {
+				log.Println(err)
+				c.mu.Lock()
+				c.Errors = append(c.Errors, err)
+				c.mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (c *Cleanup) mark(m map[string]bool, k string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m[k] = true
+}
+
+func (c *Cleanup) isDeleted(arn string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Deleted[arn]
+}
+
+// queuesDeleted reports whether the named queues of the plan are all deleted.
+func (c *Cleanup) queuesDeleted(names []string) bool {
+	for _, q := range c.Plan.Queues {
+		if contains(names, *q.JobQueueName) && !c.isDeleted(*q.JobQueueArn) {
+			return false
+		}
+	}
+	return true
+}
+
+// environmentsDeleted reports whether the compute environments of the plan
+// using a dependency are all deleted.
+func (c *Cleanup) environmentsDeleted(dep string, get func(*batch.ComputeEnvironmentDetail) string) bool {
+	for _, e := range c.Plan.Environments {
+		if get(e) == dep && !c.isDeleted(*e.ComputeEnvironmentArn) {
+			return false
+		}
+	}
+	return true
+}
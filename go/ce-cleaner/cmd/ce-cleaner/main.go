@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	cecleaner "ce-cleaner"
+)
+
+func main() {
+	cecleaner.Main(os.Args[1:])
+}
@@ -0,0 +1,252 @@
+package cecleaner
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+)
+
+// listFlag is a flag that can be repeated or given as a comma separated list.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// Filter selects the job queues and compute environments to clean up.
+// A resource is selected when its name matches one of the include globs
+// (or there are none), matches none of the exclude globs, has all the
+// tags and is older than OlderThan.
+type Filter struct {
+	Include   listFlag
+	Exclude   listFlag
+	Tags      listFlag // key=value, or key to only require the tag
+	OlderThan time.Duration
+
+	// Exact names of the job queues and compute environments to clean up
+	Queues       listFlag
+	Environments listFlag
+
+	// Load the creation times even without the age filter
+	Ages bool
+
+	created map[string]time.Time
+}
+
+// Register adds the filter flags, what names the filtered resources in the
+// help text.
+func (f *Filter) Register(fs *flag.FlagSet, what string) {
+	fs.Var(&f.Include, "include", "Only clean up "+what+" whose name matches one of these globs")
+	fs.Var(&f.Exclude, "exclude", "Never clean up "+what+" whose name matches one of these globs")
+	fs.Var(&f.Tags, "tag", "Only clean up resources with this tag, as key=value or key (repeatable)")
+	fs.DurationVar(&f.OlderThan, "older-than", 0, "Only clean up resources created longer ago than this, e.g. 24h")
+}
+
+// The CloudTrail events creating each kind of resource, with the request
+// parameter holding the name.
+var (
+	BatchCreateEvents = map[string]string{
+		"CreateJobQueue":           "jobQueueName",
+		"CreateComputeEnvironment": "computeEnvironmentName",
+	}
+	JobDefinitionCreateEvents = map[string]string{
+		"RegisterJobDefinition": "jobDefinitionName",
+	}
+)
+
+// CloudTrail only keeps 90 days of events, a resource without a creation
+// event is older than that.
+const cloudTrailRetention = 90 * 24 * time.Hour
+
+func (f *Filter) Validate() error {
+	for _, g := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return errors.New("invalid name pattern " + g + ": " + err.Error())
+		}
+	}
+	if f.OlderThan > cloudTrailRetention {
+		return errors.New("older-than can't be more than 90 days, the age of a resource comes from CloudTrail")
+	}
+	return nil
+}
+
+// Empty reports whether the filter selects every resource.
+func (f *Filter) Empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && len(f.Tags) == 0 && f.OlderThan == 0 &&
+		len(f.Queues) == 0 && len(f.Environments) == 0
+}
+
+// Match reports whether a resource passes the filter. The reason is set
+// when it doesn't.
+func (f *Filter) Match(name string, tags map[string]*string) (bool, string) {
+
+	if len(f.Include) > 0 {
+		included := false
+		for _, g := range f.Include {
+			if ok, _ := path.Match(g, name); ok {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false, "not included"
+		}
+	}
+
+	for _, g := range f.Exclude {
+		if ok, _ := path.Match(g, name); ok {
+			return false, "excluded by " + g
+		}
+	}
+
+	for _, t := range f.Tags {
+		kv := strings.SplitN(t, "=", 2)
+		value, ok := tags[kv[0]]
+		if !ok || (len(kv) == 2 && aws.StringValue(value) != kv[1]) {
+			return false, "missing tag " + t
+		}
+	}
+
+	if f.OlderThan > 0 {
+		if created, ok := f.created[name]; ok && time.Since(created) < f.OlderThan {
+			return false, "created " + created.Format(time.RFC3339)
+		}
+	}
+
+	return true, ""
+}
+
+// MatchQueue is Match for a job queue. When only compute environments are
+// named, the queues using them are selected.
+func (f *Filter) MatchQueue(q *batch.JobQueueDetail) (bool, string) {
+
+	if len(f.Queues) > 0 && !contains(f.Queues, *q.JobQueueName) {
+		return false, "not in --queues"
+	}
+	if len(f.Queues) == 0 && len(f.Environments) > 0 {
+		uses := false
+		for _, o := range q.ComputeEnvironmentOrder {
+			if contains(f.Environments, nameFromArn(aws.StringValue(o.ComputeEnvironment))) {
+				uses = true
+			}
+		}
+		if !uses {
+			return false, "doesn't use the --compute-environments"
+		}
+	}
+
+	return f.Match(*q.JobQueueName, q.Tags)
+}
+
+// MatchEnvironment is Match for a compute environment. When only queues
+// are named, the compute environments they use are selected.
+func (f *Filter) MatchEnvironment(e *batch.ComputeEnvironmentDetail, queues []*batch.JobQueueDetail) (bool, string) {
+
+	if len(f.Environments) > 0 && !contains(f.Environments, *e.ComputeEnvironmentName) {
+		return false, "not in --compute-environments"
+	}
+	if len(f.Environments) == 0 && len(f.Queues) > 0 {
+		used := false
+		for _, q := range queues {
+			for _, o := range q.ComputeEnvironmentOrder {
+				if aws.StringValue(o.ComputeEnvironment) == *e.ComputeEnvironmentArn {
+					used = true
+				}
+			}
+		}
+		if !used {
+			return false, "not used by the --queues"
+		}
+	}
+
+	return f.Match(*e.ComputeEnvironmentName, e.Tags)
+}
+
+// nameFromArn returns the name at the end of a Batch ARN, e.g.
+// arn:aws:batch:eu-west-1:123456789012:compute-environment/ci is ci.
+func nameFromArn(a string) string {
+	return a[strings.LastIndex(a, "/")+1:]
+}
+
+// LoadCreationTimes looks up when the resources were created from the
+// CloudTrail events, it is only needed for the age filter or with Ages.
+func (f *Filter) LoadCreationTimes(events map[string]string, sess *session.Session) error {
+
+	if f.OlderThan == 0 && !f.Ages {
+		return nil
+	}
+
+	f.created = map[string]time.Time{}
+
+	for event, param := range events {
+		created, err := GetCreationTimes(event, param, sess)
+		if err != nil {
+			return err
+		}
+		for k, v := range created {
+			f.created[k] = v
+		}
+	}
+
+	return nil
+}
+
+// Created returns when a resource was created, zero when CloudTrail has no
+// event for it.
+func (f *Filter) Created(name string) time.Time {
+	return f.created[name]
+}
+
+// GetCreationTimes returns the time of the latest create event of each
+// resource name found in CloudTrail.
+func GetCreationTimes(event, param string, sess *session.Session) (map[string]time.Time, error) {
+
+	created := map[string]time.Time{}
+
+	svc := cloudtrail.New(sess)
+	input := &cloudtrail.LookupEventsInput{
+		LookupAttributes: []*cloudtrail.LookupAttribute{
+			{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyEventName),
+				AttributeValue: aws.String(event),
+			},
+		},
+	}
+
+	err := svc.LookupEventsPages(input, func(page *cloudtrail.LookupEventsOutput, last bool) bool {
+		for _, e := range page.Events {
+			var ct struct {
+				RequestParameters map[string]interface{} `json:"requestParameters"`
+				ErrorCode         string                 `json:"errorCode"`
+			}
+			if err := json.Unmarshal([]byte(aws.StringValue(e.CloudTrailEvent)), &ct); err != nil || ct.ErrorCode != "" {
+				continue
+			}
+			name, _ := ct.RequestParameters[param].(string)
+			// Events come newest first, keep the latest creation of a name
+			if _, seen := created[name]; name != "" && !seen && e.EventTime != nil {
+				created[name] = *e.EventTime
+			}
+		}
+		return true
+	})
+
+	return created, err
+}
@@ -0,0 +1,124 @@
+package cecleaner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestFilterMatch(t *testing.T) {
+
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		filter Filter
+		match  string
+		tags   map[string]*string
+		ok     bool
+		reason string
+	}{
+		{
+			name:  "empty filter",
+			match: "ci-1",
+			ok:    true,
+		},
+		{
+			name:   "included",
+			filter: Filter{Include: listFlag{"prod-*", "ci-*"}},
+			match:  "ci-1",
+			ok:     true,
+		},
+		{
+			name:   "not included",
+			filter: Filter{Include: listFlag{"ci-*"}},
+			match:  "prod-1",
+			reason: "not included",
+		},
+		{
+			name:   "excluded",
+			filter: Filter{Include: listFlag{"ci-*"}, Exclude: listFlag{"ci-keep-*"}},
+			match:  "ci-keep-1",
+			reason: "excluded by ci-keep-*",
+		},
+		{
+			name:   "tag with value",
+			filter: Filter{Tags: listFlag{"env=ci"}},
+			match:  "ci-1",
+			tags:   map[string]*string{"env": aws.String("ci")},
+			ok:     true,
+		},
+		{
+			name:   "tag with another value",
+			filter: Filter{Tags: listFlag{"env=ci"}},
+			match:  "ci-1",
+			tags:   map[string]*string{"env": aws.String("prod")},
+			reason: "missing tag env=ci",
+		},
+		{
+			name:   "tag key only",
+			filter: Filter{Tags: listFlag{"temporary"}},
+			match:  "ci-1",
+			tags:   map[string]*string{"temporary": aws.String("")},
+			ok:     true,
+		},
+		{
+			name:   "missing tag",
+			filter: Filter{Tags: listFlag{"env=ci", "temporary"}},
+			match:  "ci-1",
+			tags:   map[string]*string{"env": aws.String("ci")},
+			reason: "missing tag temporary",
+		},
+		{
+			name:   "old enough",
+			filter: Filter{OlderThan: time.Hour, created: map[string]time.Time{"ci-1": now.Add(-2 * time.Hour)}},
+			match:  "ci-1",
+			ok:     true,
+		},
+		{
+			name:   "too recent",
+			filter: Filter{OlderThan: time.Hour, created: map[string]time.Time{"ci-1": now.Add(-time.Minute)}},
+			match:  "ci-1",
+			reason: "created " + now.Add(-time.Minute).Format(time.RFC3339),
+		},
+		{
+			// No creation event in CloudTrail, older than 90 days
+			name:   "unknown age",
+			filter: Filter{OlderThan: time.Hour, created: map[string]time.Time{}},
+			match:  "ci-1",
+			ok:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, reason := tt.filter.Match(tt.match, tt.tags)
+			if ok != tt.ok || reason != tt.reason {
+				t.Errorf("Match(%q) = %v, %q, want %v, %q", tt.match, ok, reason, tt.ok, tt.reason)
+			}
+		})
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		filter Filter
+		valid  bool
+	}{
+		{"globs", Filter{Include: listFlag{"ci-*"}, Exclude: listFlag{"ci-[0-9]"}}, true},
+		{"invalid glob", Filter{Include: listFlag{"ci-["}}, false},
+		{"90 days", Filter{OlderThan: cloudTrailRetention}, true},
+		{"beyond CloudTrail", Filter{OlderThan: cloudTrailRetention + time.Hour}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -1,7 +1,13 @@
 module ce-cleaner
 
-go 1.17
+go 1.18
 
-require github.com/aws/aws-sdk-go v1.43.45
+require (
+	awssession v0.0.0-00010101000000-000000000000
+	github.com/aws/aws-lambda-go v1.32.0
+	github.com/aws/aws-sdk-go v1.44.122
+)
 
 require github.com/jmespath/go-jmespath v0.4.0 // indirect
+
+replace awssession => ../awssession
@@ -0,0 +1,283 @@
+package cecleaner
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+)
+
+// jobDefinitionRevision is what the cleanup needs of an ACTIVE revision,
+// accounts can have thousands of them.
+type jobDefinitionRevision struct {
+	Arn      string
+	Revision int64
+	Tags     map[string]*string
+}
+
+// JobDefinitionPlan lists per job definition name the revisions kept and
+// the ones to deregister.
+type JobDefinitionPlan struct {
+	Names      []string
+	Keep       map[string][]string
+	Deregister map[string][]string
+	Skipped    map[string]string
+}
+
+// JobDefinitions is the job-definitions command. It deregisters the ACTIVE
+// revisions of each job definition except the latest ones and the ones
+// used by recent jobs.
+func JobDefinitions(args []string) {
+
+	var (
+		filter     Filter
+		dryRun     bool
+		keep       int
+		usedWithin time.Duration
+		backup     string
+		noBackup   bool
+	)
+
+	fs := flag.NewFlagSet("job-definitions", flag.ExitOnError)
+	SessionFlags(fs)
+	filter.Register(fs, "job definitions")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the revisions that would be kept and deregistered, without changing anything")
+	fs.IntVar(&keep, "keep", 5, "The number of latest revisions to keep per job definition")
+	fs.StringVar(&backup, "backup", DefaultBackup, "Write the revisions to deregister to this file or s3://bucket/key first, {account}, {region} and {time} are replaced")
+	fs.BoolVar(&noBackup, "no-backup", false, "Deregister without writing the backup first")
+	fs.DurationVar(&usedWithin, "used-within", 0, "Also keep the revisions used by jobs created within this time, e.g. 168h")
+	fs.Parse(args)
+	if err := ApplyEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := filter.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if keep < 1 {
+		log.Fatal("keep must be at least 1")
+	}
+
+	sess := NewSession()
+
+	if err := filter.LoadCreationTimes(JobDefinitionCreateEvents, sess); err != nil {
+		log.Fatal(err)
+	}
+
+	used := map[string]bool{}
+	if usedWithin > 0 {
+		var err error
+		used, err = UsedJobDefinitions(time.Now().Add(-usedWithin), sess)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	plan, err := BuildJobDefinitionPlan(&filter, keep, used, sess)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if dryRun {
+		plan.Print(os.Stdout)
+		return
+	}
+
+	if plan.Count() > 0 && !Yes {
+		plan.Print(os.Stdout)
+		if !Confirm(os.Stdin, os.Stdout, "\nThe revisions above will be deregistered.") {
+			log.Fatal("Aborted")
+		}
+	}
+
+	if !noBackup && plan.Count() > 0 {
+		var arns []string
+		for _, n := range plan.Names {
+			arns = append(arns, plan.Deregister[n]...)
+		}
+		b, err := NewJobDefinitionBundle(arns, sess)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dest := BackupPath(backup, b.Account, b.Region)
+		log.Println("Writing backup:", dest)
+		if err := WriteBundle(b, dest, sess); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, n := range sortedKeys(plan.Skipped) {
+		log.Println("Skipping job definition:", n, "("+plan.Skipped[n]+")")
+	}
+
+	count := 0
+	for _, n := range plan.Names {
+		for _, arn := range plan.Deregister[n] {
+			log.Println("Deregistering job definition:", arn)
+			if _, err := DeregisterJobDefinition(arn, sess); err != nil {
+				log.Println(err)
+				continue
+			}
+			count++
+		}
+	}
+	log.Println("Deregistered", count, "job definition revisions")
+}
+
+// UsedJobDefinitions returns the ARNs of the job definition revisions used
+// by the jobs created since a time in any queue.
+func UsedJobDefinitions(since time.Time, sess *session.Session) (map[string]bool, error) {
+
+	used := map[string]bool{}
+
+	var queues []string
+	err := EachJobQueue(func(i *batch.JobQueueDetail) bool {
+		queues = append(queues, *i.JobQueueName)
+		return true
+	}, sess)
+	if err != nil {
+		return used, err
+	}
+
+	for _, q := range queues {
+		jobs, err := ListJobsSince(q, since, sess)
+		if err != nil {
+			return used, err
+		}
+		for _, j := range jobs {
+			if j.JobDefinition != nil {
+				used[*j.JobDefinition] = true
+			}
+		}
+	}
+
+	return used, nil
+}
+
+// BuildJobDefinitionPlan keeps the latest revisions of each job definition
+// selected by the filter and the used ones, the rest is deregistered. The
+// filter matches the tags of the latest revision.
+func BuildJobDefinitionPlan(filter *Filter, keep int, used map[string]bool, sess *session.Session) (*JobDefinitionPlan, error) {
+
+	revisions := map[string][]jobDefinitionRevision{}
+
+	err := EachJobDefinition("ACTIVE", func(i *batch.JobDefinition) bool {
+		revisions[*i.JobDefinitionName] = append(revisions[*i.JobDefinitionName], jobDefinitionRevision{
+			Arn:      *i.JobDefinitionArn,
+			Revision: aws.Int64Value(i.Revision),
+			Tags:     i.Tags,
+		})
+		return true
+	}, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	return planJobDefinitions(revisions, filter, keep, used), nil
+}
+
+// planJobDefinitions splits the ACTIVE revisions of each job definition
+// between the ones to keep and the ones to deregister.
+func planJobDefinitions(revisions map[string][]jobDefinitionRevision, filter *Filter, keep int, used map[string]bool) *JobDefinitionPlan {
+
+	p := &JobDefinitionPlan{
+		Keep:       map[string][]string{},
+		Deregister: map[string][]string{},
+		Skipped:    map[string]string{},
+	}
+
+	for n, r := range revisions {
+		sort.Slice(r, func(i, j int) bool { return r[i].Revision > r[j].Revision })
+
+		if ok, reason := filter.Match(n, r[0].Tags); !ok {
+			p.Skipped[n] = reason
+			continue
+		}
+		p.Names = append(p.Names, n)
+
+		for i, rev := range r {
+			if i < keep || used[rev.Arn] {
+				p.Keep[n] = append(p.Keep[n], rev.Arn)
+				continue
+			}
+			p.Deregister[n] = append(p.Deregister[n], rev.Arn)
+		}
+	}
+	sort.Strings(p.Names)
+
+	return p
+}
+
+// Count returns the number of revisions to deregister.
+func (p *JobDefinitionPlan) Count() int {
+	count := 0
+	for _, r := range p.Deregister {
+		count += len(r)
+	}
+	return count
+}
+
+// Print writes the revisions kept and deregistered per job definition.
+func (p *JobDefinitionPlan) Print(w io.Writer) {
+
+	for _, n := range p.Names {
+		if len(p.Deregister[n]) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, "JobDefinition", n)
+		for _, arn := range p.Keep[n] {
+			fmt.Fprintln(w, "  keep      ", arn)
+		}
+		for _, arn := range p.Deregister[n] {
+			fmt.Fprintln(w, "  deregister", arn)
+		}
+	}
+
+	if len(p.Skipped) > 0 {
+		fmt.Fprintln(w, "\nKept:")
+		for _, n := range sortedKeys(p.Skipped) {
+			fmt.Fprintf(w, "  %s (%s)\n", n, p.Skipped[n])
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d job definition revisions would be deregistered\n", p.Count())
+}
+
+func DeregisterJobDefinition(jd string, sess *session.Session) (*batch.DeregisterJobDefinitionOutput, error) {
+
+	var result *batch.DeregisterJobDefinitionOutput
+
+	svc := batch.New(sess)
+	input := &batch.DeregisterJobDefinitionInput{
+		JobDefinition: aws.String(jd),
+	}
+
+	result, err := svc.DeregisterJobDefinition(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return result, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return result, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package cecleaner
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func testRevisions(name string, n int, tags map[string]*string) []jobDefinitionRevision {
+	var r []jobDefinitionRevision
+	for i := 1; i <= n; i++ {
+		r = append(r, jobDefinitionRevision{Arn: testRevisionArn(name, i), Revision: int64(i), Tags: tags})
+	}
+	return r
+}
+
+func testRevisionArn(name string, revision int) string {
+	return "arn:aws:batch:eu-west-1:123456789012:job-definition/" + name + ":" + strconv.Itoa(revision)
+}
+
+func TestPlanJobDefinitions(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		revisions  map[string][]jobDefinitionRevision
+		filter     Filter
+		keep       int
+		used       map[string]bool
+		names      []string
+		deregister map[string][]string
+		skipped    map[string]string
+	}{
+		{
+			name:       "keeps the latest revisions",
+			revisions:  map[string][]jobDefinitionRevision{"ci": testRevisions("ci", 4, nil)},
+			keep:       2,
+			names:      []string{"ci"},
+			deregister: map[string][]string{"ci": {testRevisionArn("ci", 2), testRevisionArn("ci", 1)}},
+		},
+		{
+			name:       "fewer revisions than kept",
+			revisions:  map[string][]jobDefinitionRevision{"ci": testRevisions("ci", 2, nil)},
+			keep:       5,
+			names:      []string{"ci"},
+			deregister: map[string][]string{},
+		},
+		{
+			name:       "keeps the used revisions",
+			revisions:  map[string][]jobDefinitionRevision{"ci": testRevisions("ci", 4, nil)},
+			keep:       1,
+			used:       map[string]bool{testRevisionArn("ci", 2): true},
+			names:      []string{"ci"},
+			deregister: map[string][]string{"ci": {testRevisionArn("ci", 3), testRevisionArn("ci", 1)}},
+		},
+		{
+			name: "unordered revisions",
+			revisions: map[string][]jobDefinitionRevision{"ci": {
+				{Arn: testRevisionArn("ci", 1), Revision: 1},
+				{Arn: testRevisionArn("ci", 3), Revision: 3},
+				{Arn: testRevisionArn("ci", 2), Revision: 2},
+			}},
+			keep:       1,
+			names:      []string{"ci"},
+			deregister: map[string][]string{"ci": {testRevisionArn("ci", 2), testRevisionArn("ci", 1)}},
+		},
+		{
+			name: "filtered names",
+			revisions: map[string][]jobDefinitionRevision{
+				"ci":   testRevisions("ci", 2, nil),
+				"prod": testRevisions("prod", 2, nil),
+			},
+			filter:     Filter{Include: listFlag{"ci*"}},
+			keep:       1,
+			names:      []string{"ci"},
+			deregister: map[string][]string{"ci": {testRevisionArn("ci", 1)}},
+			skipped:    map[string]string{"prod": "not included"},
+		},
+		{
+			// The tags of the latest revision count
+			name: "tags of the latest revision",
+			revisions: map[string][]jobDefinitionRevision{"ci": {
+				{Arn: testRevisionArn("ci", 1), Revision: 1, Tags: map[string]*string{"env": aws.String("ci")}},
+				{Arn: testRevisionArn("ci", 2), Revision: 2},
+			}},
+			filter:     Filter{Tags: listFlag{"env=ci"}},
+			keep:       1,
+			deregister: map[string][]string{},
+			skipped:    map[string]string{"ci": "missing tag env=ci"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := planJobDefinitions(tt.revisions, &tt.filter, tt.keep, tt.used)
+
+			if !reflect.DeepEqual(p.Names, tt.names) {
+				t.Errorf("names = %v, want %v", p.Names, tt.names)
+			}
+			if !reflect.DeepEqual(p.Deregister, tt.deregister) {
+				t.Errorf("deregister = %v, want %v", p.Deregister, tt.deregister)
+			}
+			if tt.skipped == nil {
+				tt.skipped = map[string]string{}
+			}
+			if !reflect.DeepEqual(p.Skipped, tt.skipped) {
+				t.Errorf("skipped = %v, want %v", p.Skipped, tt.skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package cecleaner
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+)
+
+// A queue can't be deleted while it has jobs that haven't finished. With
+// JobsWait the cleaner waits for them up to a deadline, with JobsCancel
+// queued jobs are cancelled and running ones terminated.
+const (
+	JobsWait   = "wait"
+	JobsCancel = "cancel"
+)
+
+// ActiveJobStatuses are the statuses of jobs that keep a queue busy.
+var ActiveJobStatuses = []string{
+	batch.JobStatusSubmitted,
+	batch.JobStatusPending,
+	batch.JobStatusRunnable,
+	batch.JobStatusStarting,
+	batch.JobStatusRunning,
+}
+
+// JobOptions is how the jobs of a queue are handled before it is deleted.
+type JobOptions struct {
+	Mode    string
+	Timeout time.Duration
+	Reason  string
+}
+
+func (o *JobOptions) Validate() error {
+	switch o.Mode {
+	case "", JobsWait, JobsCancel:
+		return nil
+	}
+	return errors.New("jobs must be " + JobsWait + " or " + JobsCancel)
+}
+
+func ListJobs(jq, status string, sess *session.Session) ([]*batch.JobSummary, error) {
+
+	var jobs []*batch.JobSummary
+
+	svc := batch.New(sess)
+	input := &batch.ListJobsInput{
+		JobQueue:  aws.String(jq),
+		JobStatus: aws.String(status),
+	}
+
+	err := svc.ListJobsPages(input, func(page *batch.ListJobsOutput, last bool) bool {
+		jobs = append(jobs, page.JobSummaryList...)
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return jobs, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return jobs, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return jobs, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return jobs, errors.New(err.Error())
+		}
+	}
+
+	return jobs, nil
+}
+
+// ListJobsSince returns the jobs of a queue created after a time, whatever
+// their status. Batch only keeps finished jobs for a few days.
+func ListJobsSince(jq string, since time.Time, sess *session.Session) ([]*batch.JobSummary, error) {
+
+	var jobs []*batch.JobSummary
+
+	svc := batch.New(sess)
+	input := &batch.ListJobsInput{
+		JobQueue: aws.String(jq),
+		Filters: []*batch.KeyValuesPair{
+			{
+				Name:   aws.String("AFTER_CREATED_AT"),
+				Values: []*string{aws.String(strconv.FormatInt(since.UnixNano()/int64(time.Millisecond), 10))},
+			},
+		},
+	}
+
+	err := svc.ListJobsPages(input, func(page *batch.ListJobsOutput, last bool) bool {
+		jobs = append(jobs, page.JobSummaryList...)
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return jobs, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return jobs, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return jobs, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return jobs, errors.New(err.Error())
+		}
+	}
+
+	return jobs, nil
+}
+
+// ListActiveJobs returns the jobs of a queue that haven't finished.
+func ListActiveJobs(jq string, sess *session.Session) ([]*batch.JobSummary, error) {
+
+	var jobs []*batch.JobSummary
+
+	for _, s := range ActiveJobStatuses {
+		result, err := ListJobs(jq, s, sess)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, result...)
+	}
+
+	return jobs, nil
+}
+
+func CancelJob(id, reason string, sess *session.Session) (*batch.CancelJobOutput, error) {
+
+	var result *batch.CancelJobOutput
+
+	svc := batch.New(sess)
+	input := &batch.CancelJobInput{
+		JobId:  aws.String(id),
+		Reason: aws.String(reason),
+	}
+
+	result, err := svc.CancelJob(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return result, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return result, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func TerminateJob(id, reason string, sess *session.Session) (*batch.TerminateJobOutput, error) {
+
+	var result *batch.TerminateJobOutput
+
+	svc := batch.New(sess)
+	input := &batch.TerminateJobInput{
+		JobId:  aws.String(id),
+		Reason: aws.String(reason),
+	}
+
+	result, err := svc.TerminateJob(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return result, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return result, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+// DrainQueue waits for or stops the unfinished jobs of a disabled queue. It
+// returns the jobs it found, the queue can only be deleted when the error
+// is nil.
+func DrainQueue(jq string, opts JobOptions, sess *session.Session) ([]*batch.JobSummary, error) {
+
+	jobs, err := ListActiveJobs(jq, sess)
+	if err != nil || len(jobs) == 0 {
+		return jobs, err
+	}
+
+	switch opts.Mode {
+	case JobsCancel:
+		for _, j := range jobs {
+			// Jobs that haven't started are cancelled, the others have to be
+			// terminated
+			switch aws.StringValue(j.Status) {
+			case batch.JobStatusStarting, batch.JobStatusRunning:
+				log.Println("Terminating job", *j.JobId, "("+aws.StringValue(j.JobName)+") on", jq)
+				_, err = TerminateJob(*j.JobId, opts.Reason, sess)
+			default:
+				log.Println("Cancelling job", *j.JobId, "("+aws.StringValue(j.JobName)+") on", jq)
+				_, err = CancelJob(*j.JobId, opts.Reason, sess)
+			}
+			if err != nil {
+				return jobs, err
+			}
+		}
+	case JobsWait:
+		log.Println("Waiting for", len(jobs), "jobs on", jq, "to finish")
+	default:
+		return jobs, errors.New(jq + " has unfinished jobs, use --jobs to wait for or cancel them")
+	}
+
+	// Cancelled and terminated jobs take a while to reach a final status
+	start := time.Now()
+	deadline := WaitDeadline(opts.Timeout)
+	for {
+		active, err := ListActiveJobs(jq, sess)
+		if err != nil {
+			return jobs, err
+		}
+		if len(active) == 0 {
+			return jobs, nil
+		}
+		if time.Now().After(deadline) {
+			return jobs, errors.New(jq + " still has " + strconv.Itoa(len(active)) + " unfinished jobs after " + time.Since(start).Round(time.Second).String())
+		}
+		time.Sleep(30 * time.Second)
+	}
+}
@@ -0,0 +1,180 @@
+package cecleaner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"awssession"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Result is what a cleanup planned and did, returned by the Lambda handler.
+type Result struct {
+	Region              string   `json:"region"`
+	DryRun              bool     `json:"dry_run"`
+	Queues              []string `json:"queues"`
+	ComputeEnvironments []string `json:"compute_environments"`
+	// Compute environment types by name, e.g. ECS/MANAGED/FARGATE
+	ComputeEnvironmentTypes map[string]string `json:"compute_environment_types"`
+	Roles                   []string          `json:"roles"`
+	LaunchTemplates         []string          `json:"launch_templates"`
+	InstanceProfiles        []string          `json:"instance_profiles"`
+	Skipped                 map[string]string `json:"skipped"`
+	Untagged                []string          `json:"untagged"`
+	Deleted                 []string          `json:"deleted"`
+	StoppedJobs             []string          `json:"stopped_jobs"`
+	Errors                  []string          `json:"errors"`
+}
+
+func NewResult(region string, plan *Plan, c *Cleanup) *Result {
+
+	r := &Result{
+		Region:                  region,
+		DryRun:                  c == nil,
+		Roles:                   plan.Roles,
+		LaunchTemplates:         plan.LaunchTemplates,
+		InstanceProfiles:        plan.InstanceProfiles,
+		Skipped:                 plan.Skipped,
+		ComputeEnvironmentTypes: map[string]string{},
+	}
+	for _, q := range plan.Queues {
+		r.Queues = append(r.Queues, *q.JobQueueName)
+	}
+	for _, u := range plan.Untagged {
+		r.Untagged = append(r.Untagged, u.Name)
+	}
+	for _, e := range plan.Environments {
+		r.ComputeEnvironments = append(r.ComputeEnvironments, *e.ComputeEnvironmentName)
+		r.ComputeEnvironmentTypes[*e.ComputeEnvironmentName] = EnvironmentType(e)
+	}
+
+	if c == nil {
+		return r
+	}
+
+	for a := range c.Deleted {
+		r.Deleted = append(r.Deleted, nameFromArn(a))
+	}
+	sort.Strings(r.Deleted)
+	for _, j := range c.Stopped {
+		r.StoppedJobs = append(r.StoppedJobs, aws.StringValue(j.JobId))
+	}
+	for _, err := range c.Errors {
+		r.Errors = append(r.Errors, err.Error())
+	}
+
+	return r
+}
+
+func PrintJSON(r *Result) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(b))
+}
+
+// EnvName returns the environment variable setting a flag, e.g. older-than
+// is CE_CLEANER_OLDER_THAN.
+func EnvName(flagName string) string {
+	return "CE_CLEANER_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ApplyEnv sets the flags that weren't given on the command line from the
+// environment. List flags take comma separated values.
+func ApplyEnv(fs *flag.FlagSet) error {
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(EnvName(f.Name))
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("%s: invalid value for %s: %v", EnvName(f.Name), f.Name, e)
+		}
+	})
+
+	return err
+}
+
+// InLambda reports whether the program runs as a Lambda function.
+func InLambda() bool {
+	return os.Getenv("AWS_LAMBDA_RUNTIME_API") != ""
+}
+
+// LambdaMargin is the time kept at the end of an invocation to report the
+// result once the waits are cut short.
+const LambdaMargin = time.Minute
+
+// Handler runs the cleanup configured by the CE_CLEANER_ environment
+// variables in the region of the function, without confirmation. It
+// refuses to clean up without a filter or an expiry tag, which would
+// delete everything in the region, and stops waiting before the
+// invocation times out. The result is logged as JSON and returned, the
+// invocation fails when anything couldn't be cleaned up.
+func Handler(ctx context.Context) (*Result, error) {
+
+	var opts Options
+
+	fs := flag.NewFlagSet("lambda", flag.ContinueOnError)
+	SessionFlags(fs)
+	opts.Register(fs)
+	if err := ApplyEnv(fs); err != nil {
+		return nil, err
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Filter.Empty() && !opts.TTL.Enabled() && !opts.DryRun {
+		return nil, errors.New("no filter nor expiry tag, refusing to clean up every job queue and compute environment: set " +
+			EnvName("include") + ", " + EnvName("queues") + ", " + EnvName("ttl-tag") + " or another filter, or " + EnvName("dry-run"))
+	}
+	// The file system of the function is gone after the invocation
+	if _, _, ok := s3Location(opts.Backup); !ok && !opts.NoBackup && !opts.DryRun {
+		return nil, errors.New("set " + EnvName("backup") + " to an s3://bucket/key location, or " + EnvName("no-backup") + " to clean up without a backup")
+	}
+	Yes = true
+	if d, ok := ctx.Deadline(); ok {
+		Deadline = d.Add(-LambdaMargin)
+	}
+
+	sess, err := awssession.New(Session)
+	if err != nil {
+		return nil, err
+	}
+	region := aws.StringValue(sess.Config.Region)
+
+	plan, err := opts.Plan(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *Cleanup
+	if !opts.DryRun {
+		c = opts.Run(plan, sess)
+	}
+
+	result := NewResult(region, plan, c)
+	if b, err := json.Marshal(result); err == nil {
+		log.Println(string(b))
+	}
+
+	if c != nil {
+		return result, c.Err()
+	}
+
+	return result, nil
+}
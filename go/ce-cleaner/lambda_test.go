@@ -0,0 +1,94 @@
+package cecleaner
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+
+	tests := map[string]string{
+		"yes":              "CE_CLEANER_YES",
+		"older-than":       "CE_CLEANER_OLDER_THAN",
+		"ttl-tag-untagged": "CE_CLEANER_TTL_TAG_UNTAGGED",
+		"dry-run":          "CE_CLEANER_DRY_RUN",
+	}
+
+	for flagName, want := range tests {
+		if got := EnvName(flagName); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		include []string
+		older   time.Duration
+		dryRun  bool
+		err     bool
+	}{
+		{
+			name: "no environment",
+		},
+		{
+			name:    "from the environment",
+			env:     map[string]string{"CE_CLEANER_INCLUDE": "ci-*,test-*", "CE_CLEANER_OLDER_THAN": "24h", "CE_CLEANER_DRY_RUN": "true"},
+			include: []string{"ci-*", "test-*"},
+			older:   24 * time.Hour,
+			dryRun:  true,
+		},
+		{
+			name:    "flags take precedence",
+			args:    []string{"--include", "prod-*", "--older-than", "1h"},
+			env:     map[string]string{"CE_CLEANER_INCLUDE": "ci-*", "CE_CLEANER_OLDER_THAN": "24h"},
+			include: []string{"prod-*"},
+			older:   time.Hour,
+		},
+		{
+			name: "invalid value",
+			env:  map[string]string{"CE_CLEANER_OLDER_THAN": "a day"},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			var f Filter
+			var dryRun bool
+			fs := flag.NewFlagSet("ce-cleaner", flag.ContinueOnError)
+			f.Register(fs, "resources")
+			fs.BoolVar(&dryRun, "dry-run", false, "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ApplyEnv(fs)
+			if (err != nil) != tt.err {
+				t.Fatalf("ApplyEnv() error = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if strings.Join(f.Include, ",") != strings.Join(tt.include, ",") {
+				t.Errorf("include = %v, want %v", f.Include, tt.include)
+			}
+			if f.OlderThan != tt.older {
+				t.Errorf("older-than = %v, want %v", f.OlderThan, tt.older)
+			}
+			if dryRun != tt.dryRun {
+				t.Errorf("dry-run = %v, want %v", dryRun, tt.dryRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package cecleaner
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Options are the settings of a Batch cleanup, shared by the default
+// command and the sweep.
+type Options struct {
+	Filter    Filter
+	Jobs      JobOptions
+	TTL       TTL
+	SkipRoles bool
+	DryRun    bool
+	// Also delete the service roles, instance profiles and launch
+	// templates that weren't created for the compute environments
+	DeleteUnowned bool
+	// Where the bundle of the resources is written before the cleanup,
+	// unless NoBackup is set
+	Backup   string
+	NoBackup bool
+}
+
+// Register adds the cleanup flags.
+func (o *Options) Register(fs *flag.FlagSet) {
+	o.Filter.Register(fs, "job queues and compute environments")
+	fs.Var(&o.Filter.Queues, "queues", "Only clean up these job queues (repeatable or comma separated)")
+	fs.Var(&o.Filter.Environments, "compute-environments", "Only clean up these compute environments (repeatable or comma separated)")
+	o.TTL.Register(fs)
+	fs.BoolVar(&o.SkipRoles, "skip-roles", false, "Keep the service roles of the compute environments")
+	fs.BoolVar(&o.DeleteUnowned, "delete-unowned", false, "Also delete the service roles, instance profiles and launch templates that aren't named after or tagged with "+OwnerTag+" for their compute environment")
+	fs.StringVar(&o.Backup, "backup", DefaultBackup, "Write the configuration of the resources to delete to this file or s3://bucket/key before deleting them, {account}, {region} and {time} are replaced")
+	fs.BoolVar(&o.NoBackup, "no-backup", false, "Delete without writing the backup first")
+	fs.BoolVar(&o.DryRun, "dry-run", false, "Print the dependency graph and what would be disabled and deleted, without changing anything")
+	fs.StringVar(&o.Jobs.Mode, "jobs", "", "What to do with unfinished jobs of the queues to delete: wait for them or cancel them. By default a queue with unfinished jobs isn't deleted")
+	fs.DurationVar(&o.Jobs.Timeout, "jobs-timeout", 1*time.Hour, "How long to wait for unfinished jobs to finish")
+	fs.StringVar(&o.Jobs.Reason, "jobs-reason", "Job queue cleaned up by ce-cleaner", "The reason given when cancelling or terminating jobs")
+	fs.DurationVar(&WaitTimeout, "timeout", WaitTimeout, "How long to wait for a job queue or compute environment to be disabled or deleted")
+	fs.IntVar(&Concurrency, "concurrency", Concurrency, "How many resources are disabled, waited for or deleted at the same time")
+}
+
+func (o *Options) Validate() error {
+	if err := o.Filter.Validate(); err != nil {
+		return err
+	}
+	return o.Jobs.Validate()
+}
+
+// Plan builds the plan of the cleanup in the account and region of the
+// session.
+func (o *Options) Plan(sess *session.Session) (*Plan, error) {
+
+	// Creation times are per account and region
+	filter := o.Filter
+	filter.Ages = o.TTL.Enabled()
+	if err := filter.LoadCreationTimes(BatchCreateEvents, sess); err != nil {
+		return nil, err
+	}
+
+	plan, err := BuildPlan(&filter, &o.TTL, sess)
+	if err != nil {
+		return nil, err
+	}
+	if err := plan.LoadJobs(sess); err != nil {
+		return nil, err
+	}
+	if err := plan.LoadAutoScalingGroups(sess); err != nil {
+		return nil, err
+	}
+
+	if !o.DeleteUnowned {
+		if err := plan.SkipUnowned(sess); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.SkipRoles {
+		for _, r := range plan.Roles {
+			plan.Skipped[r] = "--skip-roles"
+		}
+		plan.Roles = nil
+	}
+
+	return plan, nil
+}
+
+// Run carries out a plan, logging what is kept, handling the resources
+// without the expiry tag and writing the backup first. Nothing is deleted
+// when the backup fails.
+func (o *Options) Run(plan *Plan, sess *session.Session) *Cleanup {
+
+	for _, n := range sortedKeys(plan.Skipped) {
+		log.Println("Skipping:", n, "("+plan.Skipped[n]+")")
+	}
+	for _, d := range sortedKeys(plan.Shared) {
+		log.Println("Skipping shared dependency:", d, "(used by "+strings.Join(plan.Shared[d], ", ")+")")
+	}
+
+	c := NewCleanup(plan, o.Jobs, sess)
+	if !o.NoBackup && !plan.Empty() {
+		if err := o.WriteBackup(plan, sess); err != nil {
+			log.Println(err)
+			c.Errors = append(c.Errors, err)
+			return c
+		}
+	}
+	if o.TTL.Enabled() {
+		c.Errors = append(c.Errors, o.TTL.HandleUntagged(plan.Untagged, sess)...)
+	}
+	c.Run()
+
+	return c
+}
+
+// WriteBackup writes the bundle of a plan to the backup destination.
+func (o *Options) WriteBackup(plan *Plan, sess *session.Session) error {
+
+	b, err := NewBundle(plan, sess)
+	if err != nil {
+		return errors.New("backup failed: " + err.Error())
+	}
+
+	dest := BackupPath(o.Backup, b.Account, b.Region)
+	log.Println("Writing backup:", dest)
+	if err := WriteBundle(b, dest, sess); err != nil {
+		return errors.New("backup failed: " + err.Error())
+	}
+
+	return nil
+}
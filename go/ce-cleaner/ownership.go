@@ -0,0 +1,193 @@
+package cecleaner
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// The service roles, instance profiles and launch templates of a compute
+// environment are often shared with other compute environments, instances
+// or accounts' tooling, like ecsInstanceRole. Only the ones created for
+// the compute environment are deleted by default: named after it, e.g. ci-1
+// or ci-1-instance-profile, or tagged with OwnerTag and its name.
+const OwnerTag = "ce-cleaner:compute-environment"
+
+// Owned reports whether a dependency named name with these tags belongs to
+// one of the compute environments. The name must be the compute
+// environment's or start with it and a dash, ci-10-instance-profile isn't
+// ci-1's.
+func Owned(name string, tags map[string]string, environments []string) bool {
+	for _, e := range environments {
+		if name == e || strings.HasPrefix(name, e+"-") || tags[OwnerTag] == e {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipUnowned keeps the service roles, instance profiles and launch
+// templates that don't belong to the compute environments using them.
+func (p *Plan) SkipUnowned(sess *session.Session) error {
+
+	var roles []string
+	for _, r := range p.Roles {
+		name, err := RoleName(r)
+		if err != nil {
+			return err
+		}
+		users := p.environmentsUsing(r, serviceRole)
+		owned := Owned(name, nil, users)
+		if !owned {
+			tags, err := GetRoleTags(name, sess)
+			if err != nil && !strings.HasPrefix(err.Error(), iam.ErrCodeNoSuchEntityException) {
+				return err
+			}
+			owned = Owned(name, tags, users)
+		}
+		if !owned {
+			p.Skipped[r] = notOwned(users)
+			continue
+		}
+		roles = append(roles, r)
+	}
+	p.Roles = roles
+
+	var profiles []string
+	for _, ip := range p.InstanceProfiles {
+		name, err := InstanceProfileName(ip)
+		if err != nil {
+			return err
+		}
+		users := p.environmentsUsing(ip, instanceProfile)
+		owned := Owned(name, nil, users)
+		if !owned {
+			tags, err := GetInstanceProfileTags(name, sess)
+			if err != nil && !strings.HasPrefix(err.Error(), iam.ErrCodeNoSuchEntityException) {
+				return err
+			}
+			owned = Owned(name, tags, users)
+		}
+		if !owned {
+			p.Skipped[ip] = notOwned(users)
+			continue
+		}
+		profiles = append(profiles, ip)
+	}
+	p.InstanceProfiles = profiles
+
+	var templates []string
+	for _, lt := range p.LaunchTemplates {
+		users := p.environmentsUsing(lt, launchTemplate)
+		owned := Owned(lt, nil, users)
+		if !owned {
+			t, err := GetLaunchTemplate(lt, sess)
+			if err != nil {
+				return err
+			}
+			if t != nil {
+				tags := map[string]string{}
+				for _, tag := range t.Tags {
+					tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
+				owned = Owned(aws.StringValue(t.LaunchTemplateName), tags, users)
+			}
+		}
+		if !owned {
+			p.Skipped[lt] = notOwned(users)
+			continue
+		}
+		templates = append(templates, lt)
+	}
+	p.LaunchTemplates = templates
+
+	return nil
+}
+
+func notOwned(users []string) string {
+	return "not created for ComputeEnvironment " + strings.Join(users, ", ") + ", --delete-unowned deletes it"
+}
+
+// EnabledRegions returns the regions enabled in the account of the session.
+func EnabledRegions(sess *session.Session) ([]string, error) {
+
+	var regions []string
+
+	svc := ec2.New(sess)
+	input := &ec2.DescribeRegionsInput{}
+
+	result, err := svc.DescribeRegions(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return regions, errors.New(aerr.Code() + aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return regions, errors.New(err.Error())
+		}
+	}
+
+	for _, r := range result.Regions {
+		regions = append(regions, aws.StringValue(r.RegionName))
+	}
+
+	return regions, nil
+}
+
+// EachRegion calls fn with a copy of the session in every enabled region
+// and stops at the first error. IAM resources are global, what uses them
+// can be in any region.
+func EachRegion(fn func(region string, sess *session.Session) error, sess *session.Session) error {
+
+	regions, err := EnabledRegions(sess)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range regions {
+		if err := fn(r, sess.Copy(&aws.Config{Region: aws.String(r)})); err != nil {
+			return errors.New(r + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func GetInstanceProfileTags(p string, sess *session.Session) (map[string]string, error) {
+
+	tags := map[string]string{}
+
+	svc := iam.New(sess)
+	input := &iam.ListInstanceProfileTagsInput{
+		InstanceProfileName: aws.String(p),
+	}
+
+	result, err := svc.ListInstanceProfileTags(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return tags, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return tags, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return tags, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return tags, errors.New(err.Error())
+		}
+	}
+
+	for _, t := range result.Tags {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+
+	return tags, nil
+}
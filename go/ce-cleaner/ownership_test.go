@@ -0,0 +1,54 @@
+package cecleaner
+
+import "testing"
+
+func TestOwned(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		dependency   string
+		tags         map[string]string
+		environments []string
+		owned        bool
+	}{
+		{"named after the compute environment", "ci-1-instance-profile", nil, []string{"ci-1"}, true},
+		{"named after another one", "ci-1-instance-profile", nil, []string{"ci-2", "ci-1"}, true},
+		{"tagged", "shared-profile", map[string]string{OwnerTag: "ci-1"}, []string{"ci-1"}, true},
+		{"tagged for another one", "shared-profile", map[string]string{OwnerTag: "ci-2"}, []string{"ci-1"}, false},
+		{"shared", "ecsInstanceRole", nil, []string{"ci-1"}, false},
+		{"same name", "ci-1", nil, []string{"ci-1"}, true},
+		{"name containing the compute environment", "profile-ci-1", nil, []string{"ci-1"}, false},
+		{"longer compute environment name", "ci-10-service-role", nil, []string{"ci-1"}, false},
+		{"longer compute environment name without suffix", "ci-10", nil, []string{"ci-1"}, false},
+		{"word prefix", "production-role", nil, []string{"prod"}, false},
+		{"word prefix with a dash", "prod-role", nil, []string{"prod"}, true},
+		{"tagged despite the name", "ci-10-service-role", map[string]string{OwnerTag: "ci-1"}, []string{"ci-1"}, true},
+		{"no compute environment", "ci-1-instance-profile", nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Owned(tt.dependency, tt.tags, tt.environments); got != tt.owned {
+				t.Errorf("Owned(%q, %v, %v) = %v, want %v", tt.dependency, tt.tags, tt.environments, got, tt.owned)
+			}
+		})
+	}
+}
+
+func TestWellKnownRole(t *testing.T) {
+
+	tests := map[string]bool{
+		"AWSBatchServiceRole": true,
+		"arn:aws:iam::123456789012:role/service-role/AWSBatchServiceRole": true,
+		"arn:aws:iam::123456789012:role/ecsInstanceRole":                  true,
+		"aws-ec2-spot-fleet-tagging-role":                                 true,
+		"ci-1-service-role":                                               false,
+		"arn:aws:iam::123456789012:role/ci-1-service-role":                false,
+	}
+
+	for role, want := range tests {
+		if got := WellKnownRole(role); got != want {
+			t.Errorf("WellKnownRole(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
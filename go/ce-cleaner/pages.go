@@ -0,0 +1,155 @@
+package cecleaner
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// The Each functions go through every page of a listing and call fn for
+// each resource as the pages come in, so nothing is kept in memory unless
+// fn keeps it. Returning false from fn stops the listing.
+
+func EachJobQueue(fn func(*batch.JobQueueDetail) bool, sess *session.Session) error {
+
+	svc := batch.New(sess)
+	input := &batch.DescribeJobQueuesInput{}
+
+	err := svc.DescribeJobQueuesPages(input, func(page *batch.DescribeJobQueuesOutput, last bool) bool {
+		for _, i := range page.JobQueues {
+			if !fn(i) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return errors.New(err.Error())
+		}
+	}
+
+	return nil
+}
+
+func EachComputeEnvironment(fn func(*batch.ComputeEnvironmentDetail) bool, sess *session.Session) error {
+
+	svc := batch.New(sess)
+	input := &batch.DescribeComputeEnvironmentsInput{}
+
+	err := svc.DescribeComputeEnvironmentsPages(input, func(page *batch.DescribeComputeEnvironmentsOutput, last bool) bool {
+		for _, i := range page.ComputeEnvironments {
+			if !fn(i) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return errors.New(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// EachJobDefinition lists the job definitions with a status, ACTIVE or
+// INACTIVE, or all of them when status is empty.
+func EachJobDefinition(status string, fn func(*batch.JobDefinition) bool, sess *session.Session) error {
+
+	svc := batch.New(sess)
+	input := &batch.DescribeJobDefinitionsInput{}
+	if status != "" {
+		input.Status = aws.String(status)
+	}
+
+	err := svc.DescribeJobDefinitionsPages(input, func(page *batch.DescribeJobDefinitionsOutput, last bool) bool {
+		for _, i := range page.JobDefinitions {
+			if !fn(i) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return errors.New(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// EachRole lists the IAM roles under a path prefix, or all of them when
+// prefix is empty. ListRoles doesn't return the tags of the roles.
+func EachRole(prefix string, fn func(*iam.Role) bool, sess *session.Session) error {
+
+	svc := iam.New(sess)
+	input := &iam.ListRolesInput{}
+	if prefix != "" {
+		input.PathPrefix = aws.String(prefix)
+	}
+
+	err := svc.ListRolesPages(input, func(page *iam.ListRolesOutput, last bool) bool {
+		for _, i := range page.Roles {
+			if !fn(i) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeServiceFailureException:
+				return errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return errors.New(err.Error())
+		}
+	}
+
+	return nil
+}
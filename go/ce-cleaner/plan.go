@@ -0,0 +1,546 @@
+package cecleaner
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+)
+
+// Plan is the dependency graph of the cleanup: job queues use compute
+// environments, which use a service role and, for EC2 and Spot, an
+// instance profile and maybe a launch template. Resources are removed in
+// that order. A dependency also used by a resource that is kept is shared
+// and stays, and so do service-linked and well-known service roles.
+// Fargate compute environments only have a service role, EKS ones run in a
+// cluster and namespace that aren't Batch's and stay too.
+type Plan struct {
+	Queues       []*batch.JobQueueDetail
+	Environments []*batch.ComputeEnvironmentDetail
+	Roles        []string
+	// Left behind once the compute environments are gone
+	InstanceProfiles  []string
+	LaunchTemplates   []string
+	AutoScalingGroups map[string][]string
+
+	// Kept resources with the reason, by name
+	Skipped map[string]string
+	// Dependencies that stay because a kept resource uses them, with the
+	// resources using them
+	Shared map[string][]string
+	// Unfinished jobs by queue, only loaded when they are handled
+	Jobs map[string][]*batch.JobSummary
+	// Resources kept because they have no expiry tag
+	Untagged []Untagged
+
+	allQueues       []*batch.JobQueueDetail
+	allEnvironments []*batch.ComputeEnvironmentDetail
+}
+
+// BuildPlan lists the job queues and compute environments and selects the
+// ones to clean up with the filter and, when it is enabled, the TTL.
+func BuildPlan(filter *Filter, ttl *TTL, sess *session.Session) (*Plan, error) {
+
+	p := &Plan{
+		Skipped: map[string]string{},
+		Shared:  map[string][]string{},
+	}
+
+	// The expiry tag is only looked up for what the filter selects
+	var tagsErr error
+	expired := func(name, arn string) (bool, string) {
+		if ttl == nil || !ttl.Enabled() {
+			return true, ""
+		}
+		ok, reason, untagged, err := ttl.Match(arn, sess)
+		if err != nil {
+			tagsErr = err
+			return false, err.Error()
+		}
+		if untagged {
+			p.Untagged = append(p.Untagged, Untagged{Name: name, Arn: arn, Created: filter.Created(name)})
+		}
+		return ok, reason
+	}
+
+	// Only what the graph needs is kept of the resources that stay
+	err := EachJobQueue(func(i *batch.JobQueueDetail) bool {
+		ok, reason := filter.MatchQueue(i)
+		if ok {
+			ok, reason = expired(*i.JobQueueName, *i.JobQueueArn)
+		}
+		if tagsErr != nil {
+			return false
+		}
+		if !ok {
+			p.Skipped[*i.JobQueueName] = reason
+			p.allQueues = append(p.allQueues, &batch.JobQueueDetail{
+				JobQueueName:            i.JobQueueName,
+				JobQueueArn:             i.JobQueueArn,
+				ComputeEnvironmentOrder: i.ComputeEnvironmentOrder,
+			})
+			return true
+		}
+		p.Queues = append(p.Queues, i)
+		p.allQueues = append(p.allQueues, i)
+		return true
+	}, sess)
+	if err == nil {
+		err = tagsErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A compute environment can't be deleted while a queue that is kept
+	// still uses it
+	err = EachComputeEnvironment(func(i *batch.ComputeEnvironmentDetail) bool {
+		reason := ""
+		if ok, r := filter.MatchEnvironment(i, p.Queues); !ok {
+			reason = r
+		} else if ok, r := expired(*i.ComputeEnvironmentName, *i.ComputeEnvironmentArn); !ok {
+			if tagsErr != nil {
+				return false
+			}
+			reason = r
+		} else if users := p.keptQueuesUsing(*i.ComputeEnvironmentArn); len(users) > 0 {
+			reason = "used by JobQueue " + strings.Join(users, ", ")
+		}
+		if reason == "" {
+			p.Environments = append(p.Environments, i)
+			p.allEnvironments = append(p.allEnvironments, i)
+			return true
+		}
+		p.Skipped[*i.ComputeEnvironmentName] = reason
+		kept := &batch.ComputeEnvironmentDetail{
+			ComputeEnvironmentName:     i.ComputeEnvironmentName,
+			ComputeEnvironmentArn:      i.ComputeEnvironmentArn,
+			ServiceRole:                i.ServiceRole,
+			Type:                       i.Type,
+			ContainerOrchestrationType: i.ContainerOrchestrationType,
+		}
+		if r := i.ComputeResources; r != nil {
+			kept.ComputeResources = &batch.ComputeResource{
+				Type:           r.Type,
+				InstanceRole:   r.InstanceRole,
+				LaunchTemplate: r.LaunchTemplate,
+			}
+		}
+		p.allEnvironments = append(p.allEnvironments, kept)
+		return true
+	}, sess)
+	if err == nil {
+		err = tagsErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range p.Environments {
+		for _, d := range dependencies(i) {
+			if users := p.keptEnvironmentsUsing(d); len(users) > 0 {
+				p.Shared[d] = users
+			}
+		}
+		if i.ServiceRole != nil && ServiceLinkedRole(*i.ServiceRole) {
+			p.Skipped[*i.ServiceRole] = "service-linked role"
+		} else if i.ServiceRole != nil && WellKnownRole(*i.ServiceRole) {
+			p.Skipped[*i.ServiceRole] = "well-known service role"
+		} else if i.ServiceRole != nil && p.Shared[*i.ServiceRole] == nil && !contains(p.Roles, *i.ServiceRole) {
+			p.Roles = append(p.Roles, *i.ServiceRole)
+		}
+		if ip := instanceProfile(i); ip != "" && p.Shared[ip] == nil && !contains(p.InstanceProfiles, ip) {
+			p.InstanceProfiles = append(p.InstanceProfiles, ip)
+		}
+		if lt := launchTemplate(i); lt != "" && p.Shared[lt] == nil && !contains(p.LaunchTemplates, lt) {
+			p.LaunchTemplates = append(p.LaunchTemplates, lt)
+		}
+	}
+
+	return p, nil
+}
+
+// LoadJobs lists the unfinished jobs of the queues to delete.
+func (p *Plan) LoadJobs(sess *session.Session) error {
+
+	p.Jobs = map[string][]*batch.JobSummary{}
+
+	for _, q := range p.Queues {
+		jobs, err := ListActiveJobs(*q.JobQueueName, sess)
+		if err != nil {
+			return err
+		}
+		if len(jobs) > 0 {
+			p.Jobs[*q.JobQueueName] = jobs
+		}
+	}
+
+	return nil
+}
+
+// LoadAutoScalingGroups finds the Auto Scaling groups of the compute
+// environments to delete.
+func (p *Plan) LoadAutoScalingGroups(sess *session.Session) error {
+
+	// Only Batch's own EC2 and Spot instances are in its Auto Scaling
+	// groups, an unmanaged compute environment's instances are not
+	var prefixes []string
+	for _, e := range p.Environments {
+		if instances(e) {
+			prefixes = append(prefixes, AutoScalingGroupPrefix(*e.ComputeEnvironmentName))
+		}
+	}
+
+	groups, err := GetAutoScalingGroups(prefixes, sess)
+	if err != nil {
+		return err
+	}
+
+	p.AutoScalingGroups = map[string][]string{}
+	for _, e := range p.Environments {
+		if g := groups[AutoScalingGroupPrefix(*e.ComputeEnvironmentName)]; instances(e) && len(g) > 0 {
+			p.AutoScalingGroups[*e.ComputeEnvironmentName] = g
+		}
+	}
+
+	return nil
+}
+
+// dependencies returns the service role, instance profile and launch
+// template of a compute environment.
+func dependencies(ce *batch.ComputeEnvironmentDetail) []string {
+
+	var deps []string
+
+	if ce.ServiceRole != nil {
+		deps = append(deps, *ce.ServiceRole)
+	}
+	if ip := instanceProfile(ce); ip != "" {
+		deps = append(deps, ip)
+	}
+	if lt := launchTemplate(ce); lt != "" {
+		deps = append(deps, lt)
+	}
+
+	return deps
+}
+
+// EnvironmentType describes the flavour of a compute environment as its
+// orchestration, type and compute resources type, e.g. ECS/MANAGED/FARGATE
+// or EKS/MANAGED/SPOT.
+func EnvironmentType(ce *batch.ComputeEnvironmentDetail) string {
+
+	t := []string{batch.OrchestrationTypeEcs, aws.StringValue(ce.Type)}
+	if ce.ContainerOrchestrationType != nil {
+		t[0] = *ce.ContainerOrchestrationType
+	}
+	if ce.ComputeResources != nil && ce.ComputeResources.Type != nil {
+		t = append(t, *ce.ComputeResources.Type)
+	}
+
+	return strings.Join(t, "/")
+}
+
+// instances reports whether Batch launches EC2 instances for a compute
+// environment.
+func instances(ce *batch.ComputeEnvironmentDetail) bool {
+	if aws.StringValue(ce.Type) != batch.CETypeManaged || ce.ComputeResources == nil {
+		return false
+	}
+	switch aws.StringValue(ce.ComputeResources.Type) {
+	case batch.CRTypeEc2, batch.CRTypeSpot:
+		return true
+	}
+	return false
+}
+
+func serviceRole(ce *batch.ComputeEnvironmentDetail) string {
+	return aws.StringValue(ce.ServiceRole)
+}
+
+func instanceProfile(ce *batch.ComputeEnvironmentDetail) string {
+	if ce.ComputeResources == nil {
+		return ""
+	}
+	return aws.StringValue(ce.ComputeResources.InstanceRole)
+}
+
+// launchTemplate returns the ID of the launch template, or its name when
+// the compute resources refer to it by name.
+func launchTemplate(ce *batch.ComputeEnvironmentDetail) string {
+	if ce.ComputeResources == nil || ce.ComputeResources.LaunchTemplate == nil {
+		return ""
+	}
+	lt := ce.ComputeResources.LaunchTemplate
+	if lt.LaunchTemplateId != nil {
+		return *lt.LaunchTemplateId
+	}
+	return aws.StringValue(lt.LaunchTemplateName)
+}
+
+func (p *Plan) keptQueuesUsing(ceArn string) []string {
+
+	var users []string
+
+	for _, q := range p.allQueues {
+		if p.hasQueue(*q.JobQueueArn) {
+			continue
+		}
+		for _, o := range q.ComputeEnvironmentOrder {
+			if aws.StringValue(o.ComputeEnvironment) == ceArn {
+				users = append(users, *q.JobQueueName)
+			}
+		}
+	}
+
+	return users
+}
+
+func (p *Plan) keptEnvironmentsUsing(dep string) []string {
+
+	var users []string
+
+	for _, e := range p.allEnvironments {
+		if p.hasEnvironment(*e.ComputeEnvironmentArn) {
+			continue
+		}
+		if contains(dependencies(e), dep) {
+			users = append(users, *e.ComputeEnvironmentName)
+		}
+	}
+
+	return users
+}
+
+// environmentsUsing returns the names of the compute environments of the
+// plan using a dependency.
+func (p *Plan) environmentsUsing(dep string, get func(*batch.ComputeEnvironmentDetail) string) []string {
+
+	var users []string
+
+	for _, e := range p.Environments {
+		if get(e) == dep {
+			users = append(users, *e.ComputeEnvironmentName)
+		}
+	}
+
+	return users
+}
+
+func (p *Plan) hasQueue(arn string) bool {
+	for _, q := range p.Queues {
+		if *q.JobQueueArn == arn {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Plan) hasEnvironment(arn string) bool {
+	for _, e := range p.Environments {
+		if *e.ComputeEnvironmentArn == arn {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(l []string, s string) bool {
+	for _, i := range l {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether there is nothing to clean up.
+func (p *Plan) Empty() bool {
+	return len(p.Queues) == 0 && len(p.Environments) == 0 && len(p.Roles) == 0
+}
+
+// Print writes the dependency graph, the kept and shared resources and the
+// steps of the cleanup in order.
+func (p *Plan) Print(w io.Writer) {
+
+	fmt.Fprintln(w, "Dependency graph:")
+	for _, q := range p.Queues {
+		fmt.Fprintln(w, "  JobQueue", *q.JobQueueName)
+		for _, o := range q.ComputeEnvironmentOrder {
+			e := p.environment(aws.StringValue(o.ComputeEnvironment))
+			if e == nil {
+				fmt.Fprintln(w, "    ComputeEnvironment", aws.StringValue(o.ComputeEnvironment))
+				continue
+			}
+			p.printEnvironment(w, e)
+		}
+	}
+	for _, e := range p.Environments {
+		if len(p.queuesUsing(*e.ComputeEnvironmentArn)) == 0 {
+			fmt.Fprintln(w, "  (no queue)")
+			p.printEnvironment(w, e)
+		}
+	}
+
+	if len(p.Skipped) > 0 {
+		fmt.Fprintln(w, "\nKept:")
+		for _, n := range sortedKeys(p.Skipped) {
+			fmt.Fprintf(w, "  %s (%s)\n", n, p.Skipped[n])
+		}
+	}
+
+	if len(p.Untagged) > 0 {
+		fmt.Fprintln(w, "\nWithout the expiry tag, kept:")
+		for _, u := range p.Untagged {
+			created := "created more than 90 days ago"
+			if !u.Created.IsZero() {
+				created = "created " + u.Created.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "  %s (%s)\n", u.Name, created)
+		}
+	}
+
+	if len(p.Shared) > 0 {
+		fmt.Fprintln(w, "\nShared dependencies, kept:")
+		for _, d := range sortedKeys(p.Shared) {
+			fmt.Fprintf(w, "  %s (used by %s)\n", d, strings.Join(p.Shared[d], ", "))
+		}
+	}
+
+	if len(p.Jobs) > 0 {
+		fmt.Fprintln(w, "\nUnfinished jobs:")
+		for _, q := range p.Queues {
+			for _, j := range p.Jobs[*q.JobQueueName] {
+				fmt.Fprintf(w, "  %s %s %s (%s)\n", *q.JobQueueName, aws.StringValue(j.JobId), aws.StringValue(j.JobName), aws.StringValue(j.Status))
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "\nSteps:")
+	step := 0
+	printStep := func(s ...interface{}) {
+		step++
+		fmt.Fprintf(w, "  %2d. %s\n", step, fmt.Sprint(s...))
+	}
+	for _, q := range p.Queues {
+		printStep("Disable JobQueue ", *q.JobQueueName)
+	}
+	for _, q := range p.Queues {
+		if n := len(p.Jobs[*q.JobQueueName]); n > 0 {
+			printStep("Wait for or cancel ", n, " unfinished jobs on JobQueue ", *q.JobQueueName)
+		}
+	}
+	for _, q := range p.Queues {
+		printStep("Delete JobQueue ", *q.JobQueueName)
+	}
+	for _, e := range p.Environments {
+		printStep("Disable ComputeEnvironment ", *e.ComputeEnvironmentName)
+	}
+	for _, e := range p.Environments {
+		printStep("Delete ComputeEnvironment ", *e.ComputeEnvironmentName)
+	}
+	for _, e := range p.Environments {
+		for _, g := range p.AutoScalingGroups[*e.ComputeEnvironmentName] {
+			printStep("Delete Auto Scaling group ", g)
+		}
+		if e.EcsClusterArn != nil {
+			printStep("Delete ECS cluster ", *e.EcsClusterArn, " if left behind")
+		}
+	}
+	for _, lt := range p.LaunchTemplates {
+		printStep("Delete launch template ", lt, " unless an Auto Scaling group or instance uses it")
+	}
+	for _, ip := range p.InstanceProfiles {
+		printStep("Delete instance profile ", ip, " unless an instance or compute environment uses it in any region")
+	}
+	for _, r := range p.Roles {
+		printStep("Detach the policies of service role ", r, " and delete it")
+	}
+	if step == 0 {
+		fmt.Fprintln(w, "  Nothing to clean up")
+	}
+}
+
+func (p *Plan) printEnvironment(w io.Writer, e *batch.ComputeEnvironmentDetail) {
+
+	state := ""
+	if !p.hasEnvironment(*e.ComputeEnvironmentArn) {
+		state = " (kept)"
+	}
+	fmt.Fprintln(w, "    ComputeEnvironment", *e.ComputeEnvironmentName+" "+EnvironmentType(e)+state)
+
+	labels := []string{"ServiceRole", "InstanceProfile", "LaunchTemplate", "EcsCluster", "EksCluster", "KubernetesNamespace"}
+	deps := map[string]string{
+		"ServiceRole":     aws.StringValue(e.ServiceRole),
+		"InstanceProfile": instanceProfile(e),
+		"LaunchTemplate":  launchTemplate(e),
+		"EcsCluster":      aws.StringValue(e.EcsClusterArn),
+	}
+	if e.EksConfiguration != nil {
+		deps["EksCluster"] = aws.StringValue(e.EksConfiguration.EksClusterArn) + " (kept)"
+		deps["KubernetesNamespace"] = aws.StringValue(e.EksConfiguration.KubernetesNamespace) + " (kept)"
+	}
+	for _, l := range labels {
+		d := deps[l]
+		if d == "" {
+			continue
+		}
+		if users, shared := p.Shared[d]; shared {
+			d += " (shared with " + strings.Join(users, ", ") + ")"
+		} else if ServiceLinkedRole(d) {
+			d += " (service-linked, kept)"
+		}
+		fmt.Fprintln(w, "      "+l, d)
+	}
+	for _, g := range p.AutoScalingGroups[*e.ComputeEnvironmentName] {
+		fmt.Fprintln(w, "      AutoScalingGroup", g)
+	}
+}
+
+func (p *Plan) environment(arn string) *batch.ComputeEnvironmentDetail {
+	for _, e := range p.allEnvironments {
+		if *e.ComputeEnvironmentArn == arn {
+			return e
+		}
+	}
+	return nil
+}
+
+func (p *Plan) queuesUsing(ceArn string) []string {
+
+	var users []string
+
+	for _, q := range p.Queues {
+		for _, o := range q.ComputeEnvironmentOrder {
+			if aws.StringValue(o.ComputeEnvironment) == ceArn {
+				users = append(users, *q.JobQueueName)
+			}
+		}
+	}
+
+	return users
+}
+
+func sortedKeys(m interface{}) []string {
+
+	var keys []string
+
+	switch m := m.(type) {
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string][]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
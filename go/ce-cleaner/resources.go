@@ -0,0 +1,560 @@
+package cecleaner
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// A managed EC2 compute environment runs its instances in an ECS cluster,
+// through an Auto Scaling group named after the compute environment, with
+// the instance profile and launch template of its compute resources. Batch
+// usually removes the cluster and the group with the compute environment
+// but not always, and never the instance profile or the launch template.
+
+var launchTemplateIDPattern = regexp.MustCompile(`^lt-[0-9a-f]{17}$`)
+
+// AutoScalingGroupPrefix is the beginning of the names of the Auto Scaling
+// groups Batch creates for a compute environment.
+func AutoScalingGroupPrefix(ce string) string {
+	return ce + "-asg-"
+}
+
+// GetAutoScalingGroups returns the names of the Auto Scaling groups whose
+// name starts with one of the prefixes, by prefix.
+func GetAutoScalingGroups(prefixes []string, sess *session.Session) (map[string][]string, error) {
+
+	groups := map[string][]string{}
+
+	if len(prefixes) == 0 {
+		return groups, nil
+	}
+
+	svc := autoscaling.New(sess)
+	input := &autoscaling.DescribeAutoScalingGroupsInput{}
+
+	err := svc.DescribeAutoScalingGroupsPages(input, func(page *autoscaling.DescribeAutoScalingGroupsOutput, last bool) bool {
+		for _, g := range page.AutoScalingGroups {
+			for _, p := range prefixes {
+				if strings.HasPrefix(*g.AutoScalingGroupName, p) {
+					groups[p] = append(groups[p], *g.AutoScalingGroupName)
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case autoscaling.ErrCodeInvalidNextToken:
+				return groups, errors.New(autoscaling.ErrCodeInvalidNextToken + aerr.Error())
+			case autoscaling.ErrCodeResourceContentionFault:
+				return groups, errors.New(autoscaling.ErrCodeResourceContentionFault + aerr.Error())
+			default:
+				return groups, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return groups, errors.New(err.Error())
+		}
+	}
+
+	return groups, nil
+}
+
+// DeleteAutoScalingGroup deletes a group with its instances.
+func DeleteAutoScalingGroup(g string, sess *session.Session) (*autoscaling.DeleteAutoScalingGroupOutput, error) {
+
+	var result *autoscaling.DeleteAutoScalingGroupOutput
+
+	svc := autoscaling.New(sess)
+	input := &autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(g),
+		ForceDelete:          aws.Bool(true),
+	}
+
+	result, err := svc.DeleteAutoScalingGroup(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case autoscaling.ErrCodeScalingActivityInProgressFault:
+				return result, errors.New(autoscaling.ErrCodeScalingActivityInProgressFault + aerr.Error())
+			case autoscaling.ErrCodeResourceInUseFault:
+				return result, errors.New(autoscaling.ErrCodeResourceInUseFault + aerr.Error())
+			case autoscaling.ErrCodeResourceContentionFault:
+				return result, errors.New(autoscaling.ErrCodeResourceContentionFault + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func GetEcsCluster(c string, sess *session.Session) (*ecs.DescribeClustersOutput, error) {
+
+	var result *ecs.DescribeClustersOutput
+
+	svc := ecs.New(sess)
+	input := &ecs.DescribeClustersInput{
+		Clusters: []*string{
+			aws.String(c),
+		},
+	}
+
+	result, err := svc.DescribeClusters(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case ecs.ErrCodeServerException:
+				return result, errors.New(ecs.ErrCodeServerException + aerr.Error())
+			case ecs.ErrCodeClientException:
+				return result, errors.New(ecs.ErrCodeClientException + aerr.Error())
+			case ecs.ErrCodeInvalidParameterException:
+				return result, errors.New(ecs.ErrCodeInvalidParameterException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func DeleteEcsCluster(c string, sess *session.Session) (*ecs.DeleteClusterOutput, error) {
+
+	var result *ecs.DeleteClusterOutput
+
+	svc := ecs.New(sess)
+	input := &ecs.DeleteClusterInput{
+		Cluster: aws.String(c),
+	}
+
+	result, err := svc.DeleteCluster(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case ecs.ErrCodeClusterContainsContainerInstancesException:
+				return result, errors.New(ecs.ErrCodeClusterContainsContainerInstancesException + aerr.Error())
+			case ecs.ErrCodeClusterContainsServicesException:
+				return result, errors.New(ecs.ErrCodeClusterContainsServicesException + aerr.Error())
+			case ecs.ErrCodeClusterContainsTasksException:
+				return result, errors.New(ecs.ErrCodeClusterContainsTasksException + aerr.Error())
+			case ecs.ErrCodeClusterNotFoundException:
+				return result, errors.New(ecs.ErrCodeClusterNotFoundException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+// RemoveEcsCluster deletes the ECS cluster of a deleted compute environment
+// if Batch left it behind. A cluster that still runs anything is kept.
+func RemoveEcsCluster(c string, sess *session.Session) error {
+
+	result, err := GetEcsCluster(c, sess)
+	if err != nil {
+		return err
+	}
+	if len(result.Clusters) == 0 || aws.StringValue(result.Clusters[0].Status) == "INACTIVE" {
+		return nil
+	}
+
+	cluster := result.Clusters[0]
+	if aws.Int64Value(cluster.RegisteredContainerInstancesCount) > 0 || aws.Int64Value(cluster.ActiveServicesCount) > 0 ||
+		aws.Int64Value(cluster.RunningTasksCount) > 0 || aws.Int64Value(cluster.PendingTasksCount) > 0 {
+		return errors.New("ECS cluster " + c + " still has container instances, services or tasks")
+	}
+
+	log.Println("Deleting ECS cluster:", c)
+	_, err = DeleteEcsCluster(c, sess)
+
+	return err
+}
+
+// GetLaunchTemplate returns a launch template by ID or name, nil when it
+// doesn't exist.
+func GetLaunchTemplate(lt string, sess *session.Session) (*ec2.LaunchTemplate, error) {
+
+	svc := ec2.New(sess)
+	input := &ec2.DescribeLaunchTemplatesInput{}
+	if launchTemplateIDPattern.MatchString(lt) {
+		input.LaunchTemplateIds = []*string{aws.String(lt)}
+	} else {
+		input.LaunchTemplateNames = []*string{aws.String(lt)}
+	}
+
+	result, err := svc.DescribeLaunchTemplates(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case "InvalidLaunchTemplateId.NotFound", "InvalidLaunchTemplateName.NotFoundException":
+				return nil, nil
+			default:
+				return nil, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return nil, errors.New(err.Error())
+		}
+	}
+	if len(result.LaunchTemplates) == 0 {
+		return nil, nil
+	}
+
+	return result.LaunchTemplates[0], nil
+}
+
+// DeleteLaunchTemplate deletes a launch template by ID or name, compute
+// resources can refer to it either way.
+func DeleteLaunchTemplate(lt string, sess *session.Session) (*ec2.DeleteLaunchTemplateOutput, error) {
+
+	var result *ec2.DeleteLaunchTemplateOutput
+
+	svc := ec2.New(sess)
+	input := &ec2.DeleteLaunchTemplateInput{}
+	if launchTemplateIDPattern.MatchString(lt) {
+		input.LaunchTemplateId = aws.String(lt)
+	} else {
+		input.LaunchTemplateName = aws.String(lt)
+	}
+
+	result, err := svc.DeleteLaunchTemplate(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			// Already gone
+			case "InvalidLaunchTemplateId.NotFound", "InvalidLaunchTemplateName.NotFoundException":
+				return result, nil
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+// LaunchTemplateUsers returns the compute environments, Auto Scaling
+// groups and EC2 instances still using a launch template. Launch templates
+// are regional, only the region of the session can use it.
+func LaunchTemplateUsers(lt *ec2.LaunchTemplate, sess *session.Session) ([]string, error) {
+
+	var users []string
+
+	uses := func(s *autoscaling.LaunchTemplateSpecification) bool {
+		return s != nil && (aws.StringValue(s.LaunchTemplateId) == *lt.LaunchTemplateId ||
+			aws.StringValue(s.LaunchTemplateName) == *lt.LaunchTemplateName)
+	}
+
+	err := EachComputeEnvironment(func(i *batch.ComputeEnvironmentDetail) bool {
+		if aws.StringValue(i.Status) == batch.CEStatusDeleted {
+			return true
+		}
+		if n := launchTemplate(i); n == *lt.LaunchTemplateId || n == *lt.LaunchTemplateName {
+			users = append(users, "ComputeEnvironment "+*i.ComputeEnvironmentName)
+		}
+		return true
+	}, sess)
+	if err != nil {
+		return users, err
+	}
+
+	// Groups being deleted still hold on to their instances, which are
+	// looked at below
+	svc := autoscaling.New(sess)
+	err = svc.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{}, func(page *autoscaling.DescribeAutoScalingGroupsOutput, last bool) bool {
+		for _, g := range page.AutoScalingGroups {
+			if g.Status != nil {
+				continue
+			}
+			mixed := g.MixedInstancesPolicy != nil && g.MixedInstancesPolicy.LaunchTemplate != nil &&
+				uses(g.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification)
+			if uses(g.LaunchTemplate) || mixed {
+				users = append(users, "Auto Scaling group "+*g.AutoScalingGroupName)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return users, errors.New(aerr.Code() + aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return users, errors.New(err.Error())
+		}
+	}
+
+	// EC2 tags the instances launched from a template with its ID
+	instances, err := GetInstances([]*ec2.Filter{
+		{
+			Name:   aws.String("tag:aws:ec2launchtemplate:id"),
+			Values: []*string{lt.LaunchTemplateId},
+		},
+		{
+			Name:   aws.String("instance-state-name"),
+			Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped"}),
+		},
+	}, sess)
+	for _, i := range instances {
+		users = append(users, "instance "+i)
+	}
+
+	return users, err
+}
+
+// GetInstances returns the IDs of the EC2 instances matching the filters.
+func GetInstances(filters []*ec2.Filter, sess *session.Session) ([]string, error) {
+
+	var result []string
+
+	svc := ec2.New(sess)
+	input := &ec2.DescribeInstancesInput{
+		Filters: filters,
+	}
+
+	err := svc.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				result = append(result, aws.StringValue(i.InstanceId))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return result, errors.New(aerr.Code() + aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+// RemoveLaunchTemplate deletes a launch template that no compute
+// environment, Auto Scaling group or instance uses any more.
+func RemoveLaunchTemplate(lt string, sess *session.Session) error {
+
+	t, err := GetLaunchTemplate(lt, sess)
+	if err != nil || t == nil {
+		return err
+	}
+
+	users, err := LaunchTemplateUsers(t, sess)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return errors.New("launch template " + lt + " is still used by " + strings.Join(users, ", "))
+	}
+
+	log.Println("Deleting launch template:", lt)
+	_, err = DeleteLaunchTemplate(*t.LaunchTemplateId, sess)
+
+	return err
+}
+
+// InstanceProfileUsers returns the EC2 instances the instance profile is
+// associated with and the compute environments using it. Instance profiles
+// are global, so every enabled region is looked at.
+func InstanceProfileUsers(p *iam.InstanceProfile, sess *session.Session) ([]string, error) {
+
+	var users []string
+
+	err := EachRegion(func(region string, sess *session.Session) error {
+		instances, err := InstanceProfileAssociations(*p.Arn, sess)
+		if err != nil {
+			return err
+		}
+		for _, i := range instances {
+			users = append(users, i+" in "+region)
+		}
+		return EachComputeEnvironment(func(i *batch.ComputeEnvironmentDetail) bool {
+			if aws.StringValue(i.Status) == batch.CEStatusDeleted {
+				return true
+			}
+			if n, err := InstanceProfileName(instanceProfile(i)); err == nil && n == *p.InstanceProfileName {
+				users = append(users, "ComputeEnvironment "+*i.ComputeEnvironmentName+" in "+region)
+			}
+			return true
+		}, sess)
+	}, sess)
+
+	return users, err
+}
+
+// InstanceProfileAssociations returns the EC2 instances of the region of
+// the session the instance profile is associated with.
+func InstanceProfileAssociations(arn string, sess *session.Session) ([]string, error) {
+
+	var users []string
+
+	svc := ec2.New(sess)
+	input := &ec2.DescribeIamInstanceProfileAssociationsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("state"),
+				Values: []*string{aws.String("associating"), aws.String("associated")},
+			},
+		},
+	}
+
+	err := svc.DescribeIamInstanceProfileAssociationsPages(input, func(page *ec2.DescribeIamInstanceProfileAssociationsOutput, last bool) bool {
+		for _, a := range page.IamInstanceProfileAssociations {
+			if a.IamInstanceProfile != nil && aws.StringValue(a.IamInstanceProfile.Arn) == arn {
+				users = append(users, aws.StringValue(a.InstanceId))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return users, errors.New(aerr.Code() + aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return users, errors.New(err.Error())
+		}
+	}
+
+	return users, nil
+}
+
+func GetInstanceProfile(p string, sess *session.Session) (*iam.GetInstanceProfileOutput, error) {
+
+	var result *iam.GetInstanceProfileOutput
+
+	svc := iam.New(sess)
+	input := &iam.GetInstanceProfileInput{
+		InstanceProfileName: aws.String(p),
+	}
+
+	result, err := svc.GetInstanceProfile(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func DeleteInstanceProfile(p string, sess *session.Session) (*iam.DeleteInstanceProfileOutput, error) {
+
+	var result *iam.DeleteInstanceProfileOutput
+
+	svc := iam.New(sess)
+	input := &iam.DeleteInstanceProfileInput{
+		InstanceProfileName: aws.String(p),
+	}
+
+	result, err := svc.DeleteInstanceProfile(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeDeleteConflictException:
+				return result, errors.New(iam.ErrCodeDeleteConflictException + aerr.Error())
+			case iam.ErrCodeLimitExceededException:
+				return result, errors.New(iam.ErrCodeLimitExceededException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+// RemoveInstanceProfile deletes an instance profile that no instance or
+// compute environment uses any more, in any region. Its roles are removed
+// from it but kept.
+func RemoveInstanceProfile(p string, sess *session.Session) error {
+
+	name, err := InstanceProfileName(p)
+	if err != nil {
+		return err
+	}
+
+	result, err := GetInstanceProfile(name, sess)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), iam.ErrCodeNoSuchEntityException) {
+			return nil
+		}
+		return err
+	}
+
+	users, err := InstanceProfileUsers(result.InstanceProfile, sess)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return errors.New("instance profile " + name + " is still used by " + strings.Join(users, ", "))
+	}
+
+	for _, r := range result.InstanceProfile.Roles {
+		log.Println("Removing role", *r.RoleName, "from instance profile", name)
+		if _, err := RemoveRoleFromInstanceProfile(*r.RoleName, name, sess); err != nil {
+			return err
+		}
+	}
+
+	log.Println("Deleting instance profile:", name)
+	_, err = DeleteInstanceProfile(name, sess)
+
+	return err
+}
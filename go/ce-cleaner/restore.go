@@ -0,0 +1,806 @@
+package cecleaner
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// Restore is the restore command. It recreates what a bundle holds in
+// order: roles and their policies, instance profiles, launch templates,
+// compute environments, job queues and job definitions. Resources that already
+// exist are left alone, so a failed restore can be run again. Job
+// definitions are registered as new revisions.
+func Restore(args []string) {
+
+	var (
+		src            string
+		dryRun         bool
+		subnets        listFlag
+		securityGroups listFlag
+	)
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	SessionFlags(fs)
+	fs.StringVar(&src, "bundle", "", "The backup bundle to restore, a file or an s3://bucket/key URL")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print what would be created, without changing anything")
+	fs.Var(&subnets, "subnets", "The subnets of the restored compute environments, instead of the backed up ones (repeatable or comma separated)")
+	fs.Var(&securityGroups, "security-groups", "The security groups of the restored compute environments, instead of the backed up ones (repeatable or comma separated)")
+	fs.Parse(args)
+	if err := ApplyEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	if src == "" {
+		log.Fatal("restore needs --bundle")
+	}
+
+	sess := NewSession()
+
+	b, err := ReadBundle(src, sess)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	id, err := CallerIdentity(sess)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := &Restorer{
+		Bundle:         b,
+		Account:        id.AccountID,
+		Region:         aws.StringValue(sess.Config.Region),
+		Subnets:        subnets,
+		SecurityGroups: securityGroups,
+		sess:           sess,
+	}
+
+	if dryRun || !Yes {
+		r.Print(os.Stdout)
+	}
+	if dryRun {
+		return
+	}
+	if !Confirm(os.Stdin, os.Stdout, "\nThe resources above will be created.") {
+		log.Fatal("Aborted")
+	}
+
+	if err := r.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Restorer recreates a bundle in the account and region of its session.
+// The ARNs and policy documents of the bundle are moved to that account
+// and region.
+type Restorer struct {
+	Bundle  *Bundle
+	Account string
+	Region  string
+	// Replace the networking of the compute environments when not empty
+	Subnets        []string
+	SecurityGroups []string
+
+	sess *session.Session
+}
+
+// Print writes what the bundle holds and where it goes.
+func (r *Restorer) Print(w io.Writer) {
+
+	b := r.Bundle
+	fmt.Fprintf(w, "Bundle of %s %s from %s, restoring to %s %s:\n", b.Account, b.Region, b.Created.Format(time.RFC3339), r.Account, r.Region)
+	for _, i := range b.Roles {
+		fmt.Fprintln(w, "  Role", i.Name)
+	}
+	for _, i := range b.InstanceProfiles {
+		fmt.Fprintln(w, "  InstanceProfile", i.Name)
+	}
+	for _, i := range b.LaunchTemplates {
+		fmt.Fprintf(w, "  LaunchTemplate %s (version %d)\n", i.Name, i.Version)
+	}
+	for _, i := range b.Environments {
+		fmt.Fprintln(w, "  ComputeEnvironment", *i.ComputeEnvironmentName)
+	}
+	for _, i := range b.Queues {
+		fmt.Fprintln(w, "  JobQueue", *i.JobQueueName)
+	}
+	for _, i := range b.JobDefinitions {
+		fmt.Fprintf(w, "  JobDefinition %s (revision %d)\n", *i.JobDefinitionName, aws.Int64Value(i.Revision))
+	}
+}
+
+// Run creates the resources, stopping at the first failure.
+func (r *Restorer) Run() error {
+
+	created := false
+	for _, i := range r.Bundle.Roles {
+		ok, err := r.restoreRole(i)
+		if err != nil {
+			return err
+		}
+		created = created || ok
+	}
+	for _, i := range r.Bundle.InstanceProfiles {
+		ok, err := r.restoreInstanceProfile(i)
+		if err != nil {
+			return err
+		}
+		created = created || ok
+	}
+
+	for _, i := range r.Bundle.LaunchTemplates {
+		if err := r.restoreLaunchTemplate(i); err != nil {
+			return err
+		}
+	}
+
+	// A new role can't be assumed by Batch until IAM has propagated it
+	if created && len(r.Bundle.Environments) > 0 {
+		log.Println("Waiting for IAM to propagate the roles")
+		time.Sleep(PollInterval)
+	}
+
+	for _, i := range r.Bundle.Environments {
+		if err := r.restoreComputeEnvironment(i); err != nil {
+			return err
+		}
+	}
+	for _, i := range r.Bundle.Queues {
+		if err := r.restoreJobQueue(i); err != nil {
+			return err
+		}
+	}
+	for _, i := range r.Bundle.JobDefinitions {
+		if err := r.restoreJobDefinition(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebase moves an ARN of the backed up account and region to the ones
+// restored to. Anything else, like a plain name, is returned as is.
+func (r *Restorer) rebase(s *string) *string {
+
+	a, err := arn.Parse(aws.StringValue(s))
+	if err != nil {
+		return s
+	}
+	if a.AccountID == r.Bundle.Account {
+		a.AccountID = r.Account
+	}
+	if a.Region == r.Bundle.Region {
+		a.Region = r.Region
+	}
+
+	return aws.String(a.String())
+}
+
+// rebaseDocument moves the ARNs of the backed up account in a policy
+// document to the account restored to.
+func (r *Restorer) rebaseDocument(doc string) string {
+	return strings.ReplaceAll(doc, ":"+r.Bundle.Account+":", ":"+r.Account+":")
+}
+
+// restoreRole creates a role with its policies and reports whether it
+// was created.
+func (r *Restorer) restoreRole(role *RoleBackup) (bool, error) {
+
+	if _, err := GetRole(role.Name, r.sess); err == nil {
+		log.Println("Role", role.Name, "exists, skipping")
+		return false, nil
+	} else if !strings.HasPrefix(err.Error(), iam.ErrCodeNoSuchEntityException) {
+		return false, err
+	}
+
+	log.Println("Creating role:", role.Name)
+	if _, err := CreateRole(role.Name, role.Path, role.Description, r.rebaseDocument(role.TrustPolicy), r.sess); err != nil {
+		return false, err
+	}
+
+	for _, p := range role.AttachedPolicies {
+		policy := aws.StringValue(r.rebase(aws.String(p.Arn)))
+		if p.Document != "" {
+			log.Println("Creating policy:", p.Name)
+			_, err := CreatePolicy(p.Name, p.Path, r.rebaseDocument(p.Document), r.sess)
+			if err != nil && !strings.HasPrefix(err.Error(), iam.ErrCodeEntityAlreadyExistsException) {
+				return true, err
+			}
+		}
+		log.Println("Attaching policy", policy, "to role", role.Name)
+		if _, err := AttachRolePolicy(role.Name, policy, r.sess); err != nil {
+			return true, err
+		}
+	}
+
+	for _, n := range sortedKeys(role.InlinePolicies) {
+		log.Println("Putting inline policy", n, "of role", role.Name)
+		if _, err := PutRolePolicy(role.Name, n, r.rebaseDocument(role.InlinePolicies[n]), r.sess); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// restoreInstanceProfile creates an instance profile with its roles and
+// reports whether it was created.
+func (r *Restorer) restoreInstanceProfile(ip *InstanceProfileBackup) (bool, error) {
+
+	if _, err := GetInstanceProfile(ip.Name, r.sess); err == nil {
+		log.Println("Instance profile", ip.Name, "exists, skipping")
+		return false, nil
+	} else if !strings.HasPrefix(err.Error(), iam.ErrCodeNoSuchEntityException) {
+		return false, err
+	}
+
+	log.Println("Creating instance profile:", ip.Name)
+	if _, err := CreateInstanceProfile(ip.Name, ip.Path, r.sess); err != nil {
+		return false, err
+	}
+	for _, role := range ip.Roles {
+		log.Println("Adding role", role, "to instance profile", ip.Name)
+		if _, err := AddRoleToInstanceProfile(role, ip.Name, r.sess); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+func (r *Restorer) restoreComputeEnvironment(ce *batch.ComputeEnvironmentDetail) error {
+
+	name := *ce.ComputeEnvironmentName
+
+	if s, err := ComputeEnvironmentState(name, r.sess); err != nil {
+		return err
+	} else if s.Found && !s.Deleting() {
+		log.Println("ComputeEnvironment", name, "exists, skipping")
+		return nil
+	}
+
+	input := &batch.CreateComputeEnvironmentInput{
+		ComputeEnvironmentName: ce.ComputeEnvironmentName,
+		Type:                   ce.Type,
+		State:                  ce.State,
+		ServiceRole:            r.rebase(ce.ServiceRole),
+		UnmanagedvCpus:         ce.UnmanagedvCpus,
+		Tags:                   userTags(ce.Tags),
+	}
+	if ce.EksConfiguration != nil {
+		input.EksConfiguration = &batch.EksConfiguration{
+			EksClusterArn:       r.rebase(ce.EksConfiguration.EksClusterArn),
+			KubernetesNamespace: ce.EksConfiguration.KubernetesNamespace,
+		}
+	}
+	// Batch creates its service-linked role when there is no service role
+	if ServiceLinkedRole(aws.StringValue(ce.ServiceRole)) {
+		input.ServiceRole = nil
+	}
+	if ce.ComputeResources != nil {
+		cr := *ce.ComputeResources
+		cr.InstanceRole = r.rebase(cr.InstanceRole)
+		cr.SpotIamFleetRole = r.rebase(cr.SpotIamFleetRole)
+		if len(r.Subnets) > 0 {
+			cr.Subnets = aws.StringSlice(r.Subnets)
+		}
+		if len(r.SecurityGroups) > 0 {
+			cr.SecurityGroupIds = aws.StringSlice(r.SecurityGroups)
+		}
+		if cr.LaunchTemplate != nil {
+			lt, err := r.launchTemplate(cr.LaunchTemplate)
+			if err != nil {
+				return err
+			}
+			if lt == nil {
+				log.Println("Warning: launch template", launchTemplate(ce), "of ComputeEnvironment", name, "isn't in the bundle nor in the account, restoring without it")
+			}
+			cr.LaunchTemplate = lt
+		}
+		input.ComputeResources = &cr
+	}
+
+	log.Println("Creating ComputeEnvironment:", name, EnvironmentType(ce))
+	if _, err := CreateComputeEnvironment(input, r.sess); err != nil {
+		return err
+	}
+	_, err := WaitFor(name, ComputeEnvironmentState, Valid, r.sess)
+
+	return err
+}
+
+// launchTemplate returns what a restored compute environment refers to for
+// a backed up launch template: the one restored from the bundle, by name
+// since its ID changed, or the same one when it still exists. It is nil
+// when the template is gone.
+func (r *Restorer) launchTemplate(spec *batch.LaunchTemplateSpecification) (*batch.LaunchTemplateSpecification, error) {
+
+	for _, lt := range r.Bundle.LaunchTemplates {
+		if aws.StringValue(spec.LaunchTemplateId) == lt.ID || aws.StringValue(spec.LaunchTemplateName) == lt.Name {
+			return &batch.LaunchTemplateSpecification{
+				LaunchTemplateName: aws.String(lt.Name),
+				Version:            aws.String("$Default"),
+			}, nil
+		}
+	}
+
+	id := aws.StringValue(spec.LaunchTemplateId)
+	if id == "" {
+		id = aws.StringValue(spec.LaunchTemplateName)
+	}
+	t, err := GetLaunchTemplate(id, r.sess)
+	if err != nil || t == nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// restoreLaunchTemplate creates a launch template with the backed up
+// version as its only version.
+func (r *Restorer) restoreLaunchTemplate(lt *LaunchTemplateBackup) error {
+
+	if t, err := GetLaunchTemplate(lt.Name, r.sess); err != nil {
+		return err
+	} else if t != nil {
+		log.Println("Launch template", lt.Name, "exists, skipping")
+		return nil
+	}
+
+	// The request and response data only differ in their type names
+	var data ec2.RequestLaunchTemplateData
+	b, err := json.Marshal(lt.Data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(r.rebaseDocument(string(b))), &data); err != nil {
+		return err
+	}
+
+	var tags []*ec2.Tag
+	for k, v := range lt.Tags {
+		if !strings.HasPrefix(k, "aws:") {
+			tags = append(tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+	}
+
+	log.Println("Creating launch template:", lt.Name, "from version", lt.Version)
+	_, err = CreateLaunchTemplate(lt.Name, &data, tags, r.sess)
+
+	return err
+}
+
+func (r *Restorer) restoreJobQueue(jq *batch.JobQueueDetail) error {
+
+	name := *jq.JobQueueName
+
+	if s, err := JobQueueState(name, r.sess); err != nil {
+		return err
+	} else if s.Found && !s.Deleting() {
+		log.Println("JobQueue", name, "exists, skipping")
+		return nil
+	}
+
+	input := &batch.CreateJobQueueInput{
+		JobQueueName:        jq.JobQueueName,
+		Priority:            jq.Priority,
+		State:               jq.State,
+		SchedulingPolicyArn: r.rebase(jq.SchedulingPolicyArn),
+		Tags:                userTags(jq.Tags),
+	}
+	for _, o := range jq.ComputeEnvironmentOrder {
+		input.ComputeEnvironmentOrder = append(input.ComputeEnvironmentOrder, &batch.ComputeEnvironmentOrder{
+			ComputeEnvironment: r.rebase(o.ComputeEnvironment),
+			Order:              o.Order,
+		})
+	}
+
+	log.Println("Creating JobQueue:", name)
+	if _, err := CreateJobQueue(input, r.sess); err != nil {
+		return err
+	}
+	_, err := WaitFor(name, JobQueueState, Valid, r.sess)
+
+	return err
+}
+
+func (r *Restorer) restoreJobDefinition(jd *batch.JobDefinition) error {
+
+	input := &batch.RegisterJobDefinitionInput{
+		JobDefinitionName:    jd.JobDefinitionName,
+		Type:                 jd.Type,
+		Parameters:           jd.Parameters,
+		SchedulingPriority:   jd.SchedulingPriority,
+		RetryStrategy:        jd.RetryStrategy,
+		PropagateTags:        jd.PropagateTags,
+		Timeout:              jd.Timeout,
+		PlatformCapabilities: jd.PlatformCapabilities,
+		EksProperties:        jd.EksProperties,
+		Tags:                 userTags(jd.Tags),
+	}
+	if jd.ContainerProperties != nil {
+		input.ContainerProperties = r.rebaseContainer(jd.ContainerProperties)
+	}
+	if jd.NodeProperties != nil {
+		np := *jd.NodeProperties
+		np.NodeRangeProperties = nil
+		for _, n := range jd.NodeProperties.NodeRangeProperties {
+			nr := *n
+			if nr.Container != nil {
+				nr.Container = r.rebaseContainer(nr.Container)
+			}
+			np.NodeRangeProperties = append(np.NodeRangeProperties, &nr)
+		}
+		input.NodeProperties = &np
+	}
+
+	result, err := RegisterJobDefinition(input, r.sess)
+	if err != nil {
+		return err
+	}
+	log.Println("Registered JobDefinition:", *result.JobDefinitionArn, "from revision", aws.Int64Value(jd.Revision))
+
+	return nil
+}
+
+func (r *Restorer) rebaseContainer(c *batch.ContainerProperties) *batch.ContainerProperties {
+	cp := *c
+	cp.JobRoleArn = r.rebase(cp.JobRoleArn)
+	cp.ExecutionRoleArn = r.rebase(cp.ExecutionRoleArn)
+	return &cp
+}
+
+// userTags drops the tags AWS sets, they can't be given on creation.
+func userTags(tags map[string]*string) map[string]*string {
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	user := map[string]*string{}
+	for k, v := range tags {
+		if !strings.HasPrefix(k, "aws:") {
+			user[k] = v
+		}
+	}
+
+	return user
+}
+
+func CreateLaunchTemplate(name string, data *ec2.RequestLaunchTemplateData, tags []*ec2.Tag, sess *session.Session) (*ec2.CreateLaunchTemplateOutput, error) {
+
+	var result *ec2.CreateLaunchTemplateOutput
+
+	svc := ec2.New(sess)
+	input := &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(name),
+		LaunchTemplateData: data,
+	}
+	if len(tags) > 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{
+			{
+				ResourceType: aws.String(ec2.ResourceTypeLaunchTemplate),
+				Tags:         tags,
+			},
+		}
+	}
+
+	result, err := svc.CreateLaunchTemplate(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return result, errors.New(aerr.Code() + aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func CreateRole(r, path, description, trust string, sess *session.Session) (*iam.CreateRoleOutput, error) {
+
+	var result *iam.CreateRoleOutput
+
+	svc := iam.New(sess)
+	input := &iam.CreateRoleInput{
+		RoleName:                 aws.String(r),
+		Path:                     aws.String(path),
+		AssumeRolePolicyDocument: aws.String(trust),
+	}
+	if description != "" {
+		input.Description = aws.String(description)
+	}
+
+	result, err := svc.CreateRole(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeEntityAlreadyExistsException:
+				return result, errors.New(iam.ErrCodeEntityAlreadyExistsException + aerr.Error())
+			case iam.ErrCodeMalformedPolicyDocumentException:
+				return result, errors.New(iam.ErrCodeMalformedPolicyDocumentException + aerr.Error())
+			case iam.ErrCodeLimitExceededException:
+				return result, errors.New(iam.ErrCodeLimitExceededException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func CreatePolicy(p, path, document string, sess *session.Session) (*iam.CreatePolicyOutput, error) {
+
+	var result *iam.CreatePolicyOutput
+
+	svc := iam.New(sess)
+	input := &iam.CreatePolicyInput{
+		PolicyName:     aws.String(p),
+		PolicyDocument: aws.String(document),
+	}
+	if path != "" {
+		input.Path = aws.String(path)
+	}
+
+	result, err := svc.CreatePolicy(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeEntityAlreadyExistsException:
+				return result, errors.New(iam.ErrCodeEntityAlreadyExistsException + aerr.Error())
+			case iam.ErrCodeMalformedPolicyDocumentException:
+				return result, errors.New(iam.ErrCodeMalformedPolicyDocumentException + aerr.Error())
+			case iam.ErrCodeLimitExceededException:
+				return result, errors.New(iam.ErrCodeLimitExceededException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func AttachRolePolicy(r, p string, sess *session.Session) (*iam.AttachRolePolicyOutput, error) {
+
+	var result *iam.AttachRolePolicyOutput
+
+	svc := iam.New(sess)
+	input := &iam.AttachRolePolicyInput{
+		RoleName:  aws.String(r),
+		PolicyArn: aws.String(p),
+	}
+
+	result, err := svc.AttachRolePolicy(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeInvalidInputException:
+				return result, errors.New(iam.ErrCodeInvalidInputException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func PutRolePolicy(r, p, document string, sess *session.Session) (*iam.PutRolePolicyOutput, error) {
+
+	var result *iam.PutRolePolicyOutput
+
+	svc := iam.New(sess)
+	input := &iam.PutRolePolicyInput{
+		RoleName:       aws.String(r),
+		PolicyName:     aws.String(p),
+		PolicyDocument: aws.String(document),
+	}
+
+	result, err := svc.PutRolePolicy(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeMalformedPolicyDocumentException:
+				return result, errors.New(iam.ErrCodeMalformedPolicyDocumentException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func CreateInstanceProfile(p, path string, sess *session.Session) (*iam.CreateInstanceProfileOutput, error) {
+
+	var result *iam.CreateInstanceProfileOutput
+
+	svc := iam.New(sess)
+	input := &iam.CreateInstanceProfileInput{
+		InstanceProfileName: aws.String(p),
+		Path:                aws.String(path),
+	}
+
+	result, err := svc.CreateInstanceProfile(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeEntityAlreadyExistsException:
+				return result, errors.New(iam.ErrCodeEntityAlreadyExistsException + aerr.Error())
+			case iam.ErrCodeLimitExceededException:
+				return result, errors.New(iam.ErrCodeLimitExceededException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func AddRoleToInstanceProfile(r, p string, sess *session.Session) (*iam.AddRoleToInstanceProfileOutput, error) {
+
+	var result *iam.AddRoleToInstanceProfileOutput
+
+	svc := iam.New(sess)
+	input := &iam.AddRoleToInstanceProfileInput{
+		RoleName:            aws.String(r),
+		InstanceProfileName: aws.String(p),
+	}
+
+	result, err := svc.AddRoleToInstanceProfile(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeLimitExceededException:
+				return result, errors.New(iam.ErrCodeLimitExceededException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func CreateComputeEnvironment(input *batch.CreateComputeEnvironmentInput, sess *session.Session) (*batch.CreateComputeEnvironmentOutput, error) {
+
+	var result *batch.CreateComputeEnvironmentOutput
+
+	svc := batch.New(sess)
+
+	result, err := svc.CreateComputeEnvironment(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return result, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return result, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func CreateJobQueue(input *batch.CreateJobQueueInput, sess *session.Session) (*batch.CreateJobQueueOutput, error) {
+
+	var result *batch.CreateJobQueueOutput
+
+	svc := batch.New(sess)
+
+	result, err := svc.CreateJobQueue(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return result, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return result, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func RegisterJobDefinition(input *batch.RegisterJobDefinitionInput, sess *session.Session) (*batch.RegisterJobDefinitionOutput, error) {
+
+	var result *batch.RegisterJobDefinitionOutput
+
+	svc := batch.New(sess)
+
+	result, err := svc.RegisterJobDefinition(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return result, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return result, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
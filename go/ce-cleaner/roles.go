@@ -0,0 +1,412 @@
+package cecleaner
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// RoleName returns the name of a role from its ARN, e.g.
+// arn:aws:iam::123456789012:role/service-role/AWSBatchServiceRole is
+// AWSBatchServiceRole. A plain role name is returned as is.
+func RoleName(r string) (string, error) {
+	return iamName(r, "role")
+}
+
+// InstanceProfileName returns the name of an instance profile from its ARN
+// or name.
+func InstanceProfileName(p string) (string, error) {
+	return iamName(p, "instance-profile")
+}
+
+// ServiceLinkedRole reports whether a role belongs to an AWS service, like
+// AWSServiceRoleForBatch that Batch uses for compute environments created
+// without a service role. Only the service can delete it.
+func ServiceLinkedRole(r string) bool {
+	if a, err := arn.Parse(r); err == nil {
+		return strings.HasPrefix(a.Resource, "role/aws-service-role/")
+	}
+	return strings.HasPrefix(r, "AWSServiceRoleFor")
+}
+
+// Well-known roles that accounts share between their compute environments
+// and other services, often created by the console
+var wellKnownRoles = map[string]bool{
+	"AWSBatchServiceRole":             true,
+	"ecsInstanceRole":                 true,
+	"ecsTaskExecutionRole":            true,
+	"AmazonEC2SpotFleetRole":          true,
+	"AmazonEC2SpotFleetTaggingRole":   true,
+	"aws-ec2-spot-fleet-role":         true,
+	"aws-ec2-spot-fleet-tagging-role": true,
+}
+
+// WellKnownRole reports whether a role is one of the roles AWS documents
+// for Batch, ECS and Spot Fleet, which are never deleted.
+func WellKnownRole(r string) bool {
+	name, err := RoleName(r)
+	return err == nil && wellKnownRoles[name]
+}
+
+func iamName(s, resource string) (string, error) {
+
+	if !arn.IsARN(s) {
+		if strings.Contains(s, "/") {
+			return "", errors.New(s + " is not a " + resource + " name or ARN")
+		}
+		return s, nil
+	}
+
+	a, err := arn.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if a.Service != "iam" || !strings.HasPrefix(a.Resource, resource+"/") {
+		return "", errors.New(s + " is not an IAM " + resource + " ARN")
+	}
+
+	// Names can't contain a slash, everything before the last one is the
+	// path
+	return a.Resource[strings.LastIndex(a.Resource, "/")+1:], nil
+}
+
+// RoleUsers returns the compute environments that still use a role as
+// their service role, in every enabled region since roles are global.
+// Anything but DELETED counts, a compute environment being deleted still
+// needs its role.
+func RoleUsers(name string, sess *session.Session) ([]string, error) {
+
+	var users []string
+
+	err := EachRegion(func(region string, sess *session.Session) error {
+		return EachComputeEnvironment(func(i *batch.ComputeEnvironmentDetail) bool {
+			if i.ServiceRole == nil || aws.StringValue(i.Status) == batch.CEStatusDeleted {
+				return true
+			}
+			if n, err := RoleName(*i.ServiceRole); err == nil && n == name {
+				users = append(users, *i.ComputeEnvironmentName+" in "+region)
+			}
+			return true
+		}, sess)
+	}, sess)
+
+	return users, err
+}
+
+// RemoveRole deletes a role once it is no longer used by any compute
+// environment, nor through an instance profile still in use, in any
+// region. IAM refuses to delete a role with policies or instance profiles,
+// so the managed policies are detached, the inline policies deleted and
+// the role removed from its instance profiles first.
+func RemoveRole(r string, sess *session.Session) error {
+
+	name, err := RoleName(r)
+	if err != nil {
+		return err
+	}
+
+	users, err := RoleUsers(name, sess)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return errors.New("role " + name + " is still used by ComputeEnvironment " + strings.Join(users, ", "))
+	}
+
+	profiles, err := ListInstanceProfilesForRole(name, sess)
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		users, err := InstanceProfileUsers(p, sess)
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			return errors.New("role " + name + " is still used through instance profile " + *p.InstanceProfileName + " by " + strings.Join(users, ", "))
+		}
+	}
+
+	attached, err := ListAttachedRolePolicies(name, sess)
+	if err != nil {
+		return err
+	}
+	for _, p := range attached {
+		log.Println("Detaching policy", *p.PolicyArn, "from role", name)
+		if _, err := DetachRolePolicy(name, *p.PolicyArn, sess); err != nil {
+			return err
+		}
+	}
+
+	inline, err := ListRolePolicies(name, sess)
+	if err != nil {
+		return err
+	}
+	for _, p := range inline {
+		log.Println("Deleting inline policy", p, "of role", name)
+		if _, err := DeleteRolePolicy(name, p, sess); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range profiles {
+		log.Println("Removing role", name, "from instance profile", *p.InstanceProfileName)
+		if _, err := RemoveRoleFromInstanceProfile(name, *p.InstanceProfileName, sess); err != nil {
+			return err
+		}
+	}
+
+	log.Println("Deleting service role:", name)
+	_, err = DeleteRole(name, sess)
+
+	return err
+}
+
+func ListAttachedRolePolicies(r string, sess *session.Session) ([]*iam.AttachedPolicy, error) {
+
+	var result []*iam.AttachedPolicy
+
+	svc := iam.New(sess)
+	input := &iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(r),
+	}
+
+	err := svc.ListAttachedRolePoliciesPages(input, func(page *iam.ListAttachedRolePoliciesOutput, last bool) bool {
+		result = append(result, page.AttachedPolicies...)
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeInvalidInputException:
+				return result, errors.New(iam.ErrCodeInvalidInputException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func GetRoleTags(r string, sess *session.Session) (map[string]string, error) {
+
+	tags := map[string]string{}
+
+	svc := iam.New(sess)
+	input := &iam.ListRoleTagsInput{
+		RoleName: aws.String(r),
+	}
+
+	result, err := svc.ListRoleTags(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return tags, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return tags, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return tags, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return tags, errors.New(err.Error())
+		}
+	}
+
+	for _, t := range result.Tags {
+		tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+
+	return tags, nil
+}
+
+func DetachRolePolicy(r, p string, sess *session.Session) (*iam.DetachRolePolicyOutput, error) {
+
+	var result *iam.DetachRolePolicyOutput
+
+	svc := iam.New(sess)
+	input := &iam.DetachRolePolicyInput{
+		RoleName:  aws.String(r),
+		PolicyArn: aws.String(p),
+	}
+
+	result, err := svc.DetachRolePolicy(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeLimitExceededException:
+				return result, errors.New(iam.ErrCodeLimitExceededException + aerr.Error())
+			case iam.ErrCodeInvalidInputException:
+				return result, errors.New(iam.ErrCodeInvalidInputException + aerr.Error())
+			case iam.ErrCodeUnmodifiableEntityException:
+				return result, errors.New(iam.ErrCodeUnmodifiableEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func ListRolePolicies(r string, sess *session.Session) ([]string, error) {
+
+	var result []string
+
+	svc := iam.New(sess)
+	input := &iam.ListRolePoliciesInput{
+		RoleName: aws.String(r),
+	}
+
+	err := svc.ListRolePoliciesPages(input, func(page *iam.ListRolePoliciesOutput, last bool) bool {
+		result = append(result, aws.StringValueSlice(page.PolicyNames)...)
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func DeleteRolePolicy(r, p string, sess *session.Session) (*iam.DeleteRolePolicyOutput, error) {
+
+	var result *iam.DeleteRolePolicyOutput
+
+	svc := iam.New(sess)
+	input := &iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(r),
+		PolicyName: aws.String(p),
+	}
+
+	result, err := svc.DeleteRolePolicy(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeLimitExceededException:
+				return result, errors.New(iam.ErrCodeLimitExceededException + aerr.Error())
+			case iam.ErrCodeUnmodifiableEntityException:
+				return result, errors.New(iam.ErrCodeUnmodifiableEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func ListInstanceProfilesForRole(r string, sess *session.Session) ([]*iam.InstanceProfile, error) {
+
+	var result []*iam.InstanceProfile
+
+	svc := iam.New(sess)
+	input := &iam.ListInstanceProfilesForRoleInput{
+		RoleName: aws.String(r),
+	}
+
+	err := svc.ListInstanceProfilesForRolePages(input, func(page *iam.ListInstanceProfilesForRoleOutput, last bool) bool {
+		result = append(result, page.InstanceProfiles...)
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func RemoveRoleFromInstanceProfile(r, p string, sess *session.Session) (*iam.RemoveRoleFromInstanceProfileOutput, error) {
+
+	var result *iam.RemoveRoleFromInstanceProfileOutput
+
+	svc := iam.New(sess)
+	input := &iam.RemoveRoleFromInstanceProfileInput{
+		RoleName:            aws.String(r),
+		InstanceProfileName: aws.String(p),
+	}
+
+	result, err := svc.RemoveRoleFromInstanceProfile(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case iam.ErrCodeNoSuchEntityException:
+				return result, errors.New(iam.ErrCodeNoSuchEntityException + aerr.Error())
+			case iam.ErrCodeLimitExceededException:
+				return result, errors.New(iam.ErrCodeLimitExceededException + aerr.Error())
+			case iam.ErrCodeUnmodifiableEntityException:
+				return result, errors.New(iam.ErrCodeUnmodifiableEntityException + aerr.Error())
+			case iam.ErrCodeServiceFailureException:
+				return result, errors.New(iam.ErrCodeServiceFailureException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package cecleaner
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"awssession"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// The session comes from the shared config profile and region, the SDK
+// defaults apply when they are empty. With a role ARN the role is assumed
+// with the credentials of the profile.
+var (
+	Session awssession.Config
+	Yes     bool
+)
+
+// SessionFlags adds the flags selecting the account and region, and --yes.
+func SessionFlags(fs *flag.FlagSet) {
+	fs.StringVar(&Session.Profile, "profile", "", "The AWS profile to use, defaults to the AWS_PROFILE environment variable or the default profile")
+	fs.StringVar(&Session.Region, "region", "", "The AWS region to clean up, defaults to the region of the profile")
+	fs.StringVar(&Session.RoleArn, "role-arn", "", "A role to assume with the credentials of the profile")
+	fs.StringVar(&Session.ExternalID, "external-id", "", "The external ID required to assume the role")
+	fs.StringVar(&Session.MFASerial, "mfa-serial", "", "The MFA device to authenticate with, the token is asked for unless --mfa-token is given")
+	fs.StringVar(&Session.MFAToken, "mfa-token", "", "The current code of the MFA device")
+	fs.StringVar(&Session.Endpoint, "endpoint-url", "", "Send every AWS request to this endpoint, e.g. a local AWS stand-in, defaults to the AWS_ENDPOINT_URL environment variable")
+	fs.BoolVar(&Yes, "yes", false, "Don't ask for confirmation before deleting anything")
+}
+
+func NewSession() *session.Session {
+
+	sess, err := awssession.New(Session)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return sess
+}
+
+// Confirm asks the operator to type yes before going on, unless --yes was
+// given. Anything else, or no answer at all, is a no.
+func Confirm(in io.Reader, out io.Writer, question string) bool {
+
+	if Yes {
+		return true
+	}
+
+	fmt.Fprint(out, question+" Type yes to continue: ")
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(out)
+		return false
+	}
+
+	return strings.TrimSpace(answer) == "yes"
+}
@@ -0,0 +1,179 @@
+package cecleaner
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+)
+
+// Job queues and compute environments have a State, ENABLED or DISABLED,
+// and a Status that is UPDATING, CREATING or DELETING while Batch applies a
+// change and VALID, INVALID or DELETED once it is done. Describe stops
+// returning a resource a while after it is deleted.
+var (
+	WaitTimeout  = 15 * time.Minute
+	PollInterval = 10 * time.Second
+
+	// Deadline, when set, cuts every wait short, e.g. before a Lambda
+	// invocation times out
+	Deadline time.Time
+)
+
+// WaitDeadline returns when a wait of at most d started now has to end.
+func WaitDeadline(d time.Duration) time.Time {
+	end := time.Now().Add(d)
+	if !Deadline.IsZero() && Deadline.Before(end) {
+		return Deadline
+	}
+	return end
+}
+
+// ResourceState is the state of a job queue or compute environment, Found
+// is false once the resource is gone.
+type ResourceState struct {
+	Found        bool
+	State        string
+	Status       string
+	StatusReason string
+}
+
+func (s ResourceState) Busy() bool {
+	switch s.Status {
+	case batch.JQStatusCreating, batch.JQStatusUpdating:
+		return true
+	}
+	return false
+}
+
+func (s ResourceState) Deleting() bool {
+	return s.Status == batch.JQStatusDeleting || s.Status == batch.JQStatusDeleted
+}
+
+func (s ResourceState) String() string {
+	if !s.Found {
+		return "deleted"
+	}
+	return s.State + "/" + s.Status
+}
+
+func JobQueueState(jq string, sess *session.Session) (ResourceState, error) {
+
+	result, err := GetJobQueue(jq, sess)
+	if err != nil || len(result.JobQueues) == 0 {
+		return ResourceState{}, err
+	}
+	q := result.JobQueues[0]
+
+	return ResourceState{
+		Found:        true,
+		State:        aws.StringValue(q.State),
+		Status:       aws.StringValue(q.Status),
+		StatusReason: aws.StringValue(q.StatusReason),
+	}, nil
+}
+
+func ComputeEnvironmentState(ce string, sess *session.Session) (ResourceState, error) {
+
+	result, err := GetComputeEnvironment(ce, sess)
+	if err != nil || len(result.ComputeEnvironments) == 0 {
+		return ResourceState{}, err
+	}
+	e := result.ComputeEnvironments[0]
+
+	return ResourceState{
+		Found:        true,
+		State:        aws.StringValue(e.State),
+		Status:       aws.StringValue(e.Status),
+		StatusReason: aws.StringValue(e.StatusReason),
+	}, nil
+}
+
+// WaitFor polls a resource until done returns true. It fails on a
+// describe error, when the resource becomes INVALID and on timeout.
+func WaitFor(name string, get func(string, *session.Session) (ResourceState, error), done func(ResourceState) bool, sess *session.Session) (ResourceState, error) {
+
+	start := time.Now()
+	deadline := WaitDeadline(WaitTimeout)
+
+	for {
+		s, err := get(name, sess)
+		if err != nil {
+			return s, err
+		}
+		if done(s) {
+			return s, nil
+		}
+		if s.Status == batch.JQStatusInvalid {
+			return s, errors.New(name + " is INVALID: " + s.StatusReason)
+		}
+		if time.Now().After(deadline) {
+			return s, errors.New(name + " is still " + s.String() + " after " + time.Since(start).Round(time.Second).String())
+		}
+		time.Sleep(PollInterval)
+	}
+}
+
+// Disabled is done once the update to DISABLED has been applied.
+func Disabled(s ResourceState) bool {
+	return !s.Found || (s.State == batch.JQStateDisabled && !s.Busy())
+}
+
+// Deleted is done once the resource is gone.
+func Deleted(s ResourceState) bool {
+	return !s.Found || s.Status == batch.JQStatusDeleted
+}
+
+// Valid is done once a created resource can be used.
+func Valid(s ResourceState) bool {
+	return s.Found && s.Status == batch.JQStatusValid
+}
+
+// DisableAndWait disables a job queue or compute environment unless it
+// already is, or is being deleted, and waits for the change.
+func DisableAndWait(kind, name string, get func(string, *session.Session) (ResourceState, error), disable func(string, *session.Session) error, sess *session.Session) error {
+
+	// A pending update has to finish before another one is accepted
+	s, err := WaitFor(name, get, func(s ResourceState) bool { return !s.Busy() }, sess)
+	if err != nil {
+		return err
+	}
+	if !s.Found || s.Deleting() || s.State == batch.JQStateDisabled {
+		return nil
+	}
+
+	log.Println("Disabling "+kind+":", name)
+	if err := disable(name, sess); err != nil {
+		return err
+	}
+	log.Println("Waiting for "+kind, name, "to be disabled")
+	_, err = WaitFor(name, get, Disabled, sess)
+
+	return err
+}
+
+// DeleteAndWait deletes a disabled job queue or compute environment and
+// waits until it is gone.
+func DeleteAndWait(kind, name string, get func(string, *session.Session) (ResourceState, error), remove func(string, *session.Session) error, sess *session.Session) error {
+
+	s, err := get(name, sess)
+	if err != nil {
+		return err
+	}
+	if !s.Found {
+		return nil
+	}
+	if !s.Deleting() {
+		log.Println("Deleting "+kind+":", name)
+		if err := remove(name, sess); err != nil {
+			return err
+		}
+	}
+	log.Println("Waiting for "+kind, name, "to be deleted")
+	_, err = WaitFor(name, get, Deleted, sess)
+
+	return err
+}
@@ -0,0 +1,269 @@
+package cecleaner
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Target is one account and region of a sweep.
+type Target struct {
+	Account string
+	Region  string
+
+	Plan    *Plan
+	Cleanup *Cleanup
+	Err     error
+
+	sess *session.Session
+}
+
+// Sweep is the sweep command. It runs the cleanup in every region of
+// every account, listed from AWS Organizations or given on the command
+// line, assuming the same role in each. All the plans are made before
+// anything is deleted, so a single confirmation covers the whole sweep.
+func Sweep(args []string) {
+
+	var (
+		opts         Options
+		accounts     listFlag
+		excluded     listFlag
+		regions      listFlag
+		organization bool
+		roleName     string
+	)
+
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	SessionFlags(fs)
+	opts.Register(fs)
+	fs.Var(&accounts, "accounts", "The accounts to clean up (repeatable or comma separated)")
+	fs.BoolVar(&organization, "organization", false, "Clean up every active account of the organization")
+	fs.Var(&excluded, "exclude-accounts", "Accounts to leave alone (repeatable or comma separated)")
+	fs.Var(&regions, "regions", "The regions to clean up in each account (repeatable or comma separated)")
+	fs.StringVar(&roleName, "role-name", "OrganizationAccountAccessRole", "The role to assume in each account")
+	fs.Parse(args)
+	if err := ApplyEnv(fs); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := opts.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if len(accounts) == 0 && !organization {
+		log.Fatal("sweep needs --accounts or --organization")
+	}
+	if len(regions) == 0 {
+		log.Fatal("sweep needs --regions")
+	}
+	if !opts.NoBackup && (!strings.Contains(opts.Backup, "{account}") || !strings.Contains(opts.Backup, "{region}")) {
+		log.Fatal("the backup of a sweep needs {account} and {region} in its name")
+	}
+
+	sess := NewSession()
+
+	partition, err := Partition(sess)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if organization {
+		active, err := GetOrganizationAccounts(sess)
+		if err != nil {
+			log.Fatal(err)
+		}
+		accounts = append(accounts, active...)
+	}
+
+	var targets []*Target
+	for _, a := range accounts {
+		if contains(excluded, a) || containsTarget(targets, a) {
+			continue
+		}
+		role := "arn:" + partition + ":iam::" + a + ":role/" + roleName
+		for _, r := range regions {
+			targets = append(targets, &Target{
+				Account: a,
+				Region:  r,
+				sess: sess.Copy(&aws.Config{
+					Region:      aws.String(r),
+					Credentials: stscreds.NewCredentials(sess, role),
+				}),
+			})
+		}
+	}
+
+	for _, t := range targets {
+		log.Println("Planning", t.Account, t.Region)
+		t.Plan, t.Err = opts.Plan(t.sess)
+		if t.Err != nil {
+			log.Println(t.Err)
+		}
+	}
+
+	if opts.DryRun || !Yes {
+		for _, t := range targets {
+			if t.Plan == nil || t.Plan.Empty() {
+				continue
+			}
+			fmt.Printf("\n== %s %s\n", t.Account, t.Region)
+			t.Plan.Print(os.Stdout)
+		}
+	}
+	if opts.DryRun {
+		fmt.Println()
+		PrintSweepReport(os.Stdout, targets)
+		return
+	}
+
+	empty := true
+	for _, t := range targets {
+		if t.Plan != nil && !t.Plan.Empty() {
+			empty = false
+		}
+	}
+	if !empty && !Confirm(os.Stdin, os.Stdout, "\nThe resources above will be deleted in every account and region.") {
+		log.Fatal("Aborted")
+	}
+
+	for _, t := range targets {
+		if t.Plan == nil || (t.Plan.Empty() && len(t.Plan.Untagged) == 0) {
+			continue
+		}
+		log.Println("Cleaning up", t.Account, t.Region)
+		t.Cleanup = opts.Run(t.Plan, t.sess)
+		t.Err = t.Cleanup.Err()
+	}
+
+	PrintSweepReport(os.Stdout, targets)
+
+	for _, t := range targets {
+		if t.Err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+func containsTarget(targets []*Target, account string) bool {
+	for _, t := range targets {
+		if t.Account == account {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintSweepReport writes a line per account and region with what was
+// planned and deleted, then the failures.
+func PrintSweepReport(w io.Writer, targets []*Target) {
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tREGION\tQUEUES\tENVIRONMENTS\tROLES\tDELETED\tSTATUS")
+	for _, t := range targets {
+		status := "ok"
+		if t.Err != nil {
+			status = "failed"
+		}
+		if t.Plan == nil {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\t-\t%s\n", t.Account, t.Region, status)
+			continue
+		}
+		deleted := "-"
+		if t.Cleanup != nil {
+			deleted = fmt.Sprint(len(t.Cleanup.Deleted))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", t.Account, t.Region, len(t.Plan.Queues), len(t.Plan.Environments), len(t.Plan.Roles), deleted, status)
+	}
+	tw.Flush()
+
+	for _, t := range targets {
+		if t.Err != nil {
+			fmt.Fprintf(w, "\n%s %s: %s\n", t.Account, t.Region, strings.TrimSpace(t.Err.Error()))
+		}
+	}
+}
+
+// Partition returns the partition of the credentials, e.g. aws or aws-cn,
+// the role ARNs of the other accounts are in the same one.
+func Partition(sess *session.Session) (string, error) {
+
+	a, err := CallerIdentity(sess)
+	if err != nil {
+		return "", err
+	}
+
+	return a.Partition, nil
+}
+
+// CallerIdentity returns the ARN of the credentials of the session.
+func CallerIdentity(sess *session.Session) (arn.ARN, error) {
+
+	svc := sts.New(sess)
+
+	result, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			return arn.ARN{}, errors.New(aerr.Code() + aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return arn.ARN{}, errors.New(err.Error())
+		}
+	}
+
+	return arn.Parse(aws.StringValue(result.Arn))
+}
+
+// GetOrganizationAccounts returns the IDs of the active accounts of the
+// organization, it has to be called from the management account or a
+// delegated administrator.
+func GetOrganizationAccounts(sess *session.Session) ([]string, error) {
+
+	var accounts []string
+
+	svc := organizations.New(sess)
+	input := &organizations.ListAccountsInput{}
+
+	err := svc.ListAccountsPages(input, func(page *organizations.ListAccountsOutput, last bool) bool {
+		for _, a := range page.Accounts {
+			if aws.StringValue(a.Status) == organizations.AccountStatusActive {
+				accounts = append(accounts, *a.Id)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case organizations.ErrCodeAccessDeniedException:
+				return accounts, errors.New(organizations.ErrCodeAccessDeniedException + aerr.Error())
+			case organizations.ErrCodeAWSOrganizationsNotInUseException:
+				return accounts, errors.New(organizations.ErrCodeAWSOrganizationsNotInUseException + aerr.Error())
+			case organizations.ErrCodeTooManyRequestsException:
+				return accounts, errors.New(organizations.ErrCodeTooManyRequestsException + aerr.Error())
+			case organizations.ErrCodeServiceException:
+				return accounts, errors.New(organizations.ErrCodeServiceException + aerr.Error())
+			default:
+				return accounts, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return accounts, errors.New(err.Error())
+		}
+	}
+
+	return accounts, nil
+}
@@ -0,0 +1,167 @@
+package cecleaner
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/batch"
+)
+
+// TTL enforces an expiry tag on job queues and compute environments: only
+// the ones whose tag is in the past are cleaned up. The tag holds an
+// RFC3339 time or a date, e.g. expires-at=2022-06-01T00:00:00Z or
+// expires-at=2022-06-01. Untagged resources are kept and reported once
+// they are older than WarnAfter, or tagged to expire after TagUntagged.
+type TTL struct {
+	Tag         string
+	WarnAfter   time.Duration
+	TagUntagged time.Duration
+}
+
+func (t *TTL) Register(fs *flag.FlagSet) {
+	fs.StringVar(&t.Tag, "ttl-tag", "", "Only clean up job queues and compute environments whose value of this tag, e.g. expires-at, is in the past")
+	fs.DurationVar(&t.WarnAfter, "ttl-warn-after", 7*24*time.Hour, "Report the resources without the ttl-tag created longer ago than this")
+	fs.DurationVar(&t.TagUntagged, "ttl-tag-untagged", 0, "Tag the resources without the ttl-tag to expire after this, e.g. 72h")
+}
+
+func (t *TTL) Enabled() bool {
+	return t.Tag != ""
+}
+
+// ParseExpiry reads the value of the expiry tag.
+func ParseExpiry(v string) (time.Time, error) {
+	if e, err := time.Parse(time.RFC3339, v); err == nil {
+		return e, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// Match reports whether a resource has expired. untagged is true when it
+// has no expiry tag.
+func (t *TTL) Match(arn string, sess *session.Session) (ok bool, reason string, untagged bool, err error) {
+
+	tags, err := GetTags(arn, sess)
+	if err != nil {
+		return false, "", false, err
+	}
+
+	v, found := tags[t.Tag]
+	if !found {
+		return false, "no " + t.Tag + " tag", true, nil
+	}
+
+	expiry, err := ParseExpiry(aws.StringValue(v))
+	if err != nil {
+		return false, "invalid " + t.Tag + " " + aws.StringValue(v), false, nil
+	}
+	if time.Now().Before(expiry) {
+		return false, "expires " + expiry.Format(time.RFC3339), false, nil
+	}
+
+	return true, "", false, nil
+}
+
+// Untagged is a resource without the expiry tag.
+type Untagged struct {
+	Name string
+	Arn  string
+	// Zero when CloudTrail has no creation event, so it is older than 90 days
+	Created time.Time
+}
+
+// Stale reports whether an untagged resource is old enough to be reported.
+func (t *TTL) Stale(u Untagged) bool {
+	return u.Created.IsZero() || time.Since(u.Created) > t.WarnAfter
+}
+
+// HandleUntagged reports the stale untagged resources and tags all of them
+// with an expiry when TagUntagged is set.
+func (t *TTL) HandleUntagged(untagged []Untagged, sess *session.Session) []error {
+
+	var errs []error
+
+	for _, u := range untagged {
+		if t.Stale(u) {
+			created := "more than 90 days ago"
+			if !u.Created.IsZero() {
+				created = u.Created.Format(time.RFC3339)
+			}
+			log.Println("Warning:", u.Name, "has no", t.Tag, "tag and was created", created)
+		}
+		if t.TagUntagged == 0 {
+			continue
+		}
+		expiry := time.Now().Add(t.TagUntagged).UTC().Format(time.RFC3339)
+		log.Println("Tagging", u.Name, "with", t.Tag+"="+expiry)
+		if _, err := TagResource(u.Arn, map[string]string{t.Tag: expiry}, sess); err != nil {
+			log.Println(err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+func GetTags(arn string, sess *session.Session) (map[string]*string, error) {
+
+	svc := batch.New(sess)
+	input := &batch.ListTagsForResourceInput{
+		ResourceArn: aws.String(arn),
+	}
+
+	result, err := svc.ListTagsForResource(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return nil, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return nil, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return nil, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return nil, errors.New(err.Error())
+		}
+	}
+
+	return result.Tags, nil
+}
+
+func TagResource(arn string, tags map[string]string, sess *session.Session) (*batch.TagResourceOutput, error) {
+
+	var result *batch.TagResourceOutput
+
+	svc := batch.New(sess)
+	input := &batch.TagResourceInput{
+		ResourceArn: aws.String(arn),
+		Tags:        aws.StringMap(tags),
+	}
+
+	result, err := svc.TagResource(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case batch.ErrCodeClientException:
+				return result, errors.New(batch.ErrCodeClientException + aerr.Error())
+			case batch.ErrCodeServerException:
+				return result, errors.New(batch.ErrCodeServerException + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,58 @@
+package cecleaner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+
+	tests := []struct {
+		value string
+		want  time.Time
+		valid bool
+	}{
+		{"2022-06-01T12:00:00Z", time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC), true},
+		{"2022-06-01T12:00:00+02:00", time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC), true},
+		{"2022-06-01", time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"2022-13-01", time.Time{}, false},
+		{"tomorrow", time.Time{}, false},
+		{"1654084800", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseExpiry(tt.value)
+			if (err == nil) != tt.valid {
+				t.Fatalf("ParseExpiry(%q) error = %v, want valid %v", tt.value, err, tt.valid)
+			}
+			if tt.valid && !got.Equal(tt.want) {
+				t.Errorf("ParseExpiry(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTTLStale(t *testing.T) {
+
+	ttl := TTL{Tag: "expires-at", WarnAfter: 24 * time.Hour}
+
+	tests := []struct {
+		name    string
+		created time.Time
+		stale   bool
+	}{
+		{"recent", time.Now().Add(-time.Hour), false},
+		{"old", time.Now().Add(-48 * time.Hour), true},
+		{"no creation event", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ttl.Stale(Untagged{Name: "ci-1", Created: tt.created}); got != tt.stale {
+				t.Errorf("Stale() = %v, want %v", got, tt.stale)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	dbchecker "db-checker"
+)
+
+func main() {
+	dbchecker.Main(os.Args[1:])
+}
@@ -1,4 +1,4 @@
-package main
+package dbchecker
 
 import (
 	"context"
@@ -60,29 +60,31 @@ func dbConnect(ctx context.Context, user, password, address, protocol, port stri
 	return nil
 }
 
-func init() {
+// Main checks that the database given by the arguments accepts
+// connections. Missing parameters are usage errors and exit with 2, like
+// the ones reported by the flag package.
+func Main(args []string) {
+
 	// Setting all the parameters and its values
-	flag.StringVar(&user, "user", "", "The database user name")
-	flag.StringVar(&password, "password", "", "The database password")
-	flag.StringVar(&protocol, "protocol", protocol, "The Net connection protocol, defaults TCP")
-	flag.StringVar(&address, "host", "", "The database host name or IP address to connect")
-	flag.StringVar(&port, "port", port, "The database port to connect")
-	flag.Parse()
+	fs := flag.NewFlagSet("db-checker", flag.ExitOnError)
+	fs.StringVar(&user, "user", "", "The database user name")
+	fs.StringVar(&password, "password", "", "The database password")
+	fs.StringVar(&protocol, "protocol", protocol, "The Net connection protocol, defaults TCP")
+	fs.StringVar(&address, "host", "", "The database host name or IP address to connect")
+	fs.StringVar(&port, "port", port, "The database port to connect")
+	fs.Parse(args)
 
 	switch {
 	case user == "":
 		fmt.Fprint(os.Stderr, "Please, enter the user name\n")
-		os.Exit(1)
+		os.Exit(2)
 	case password == "":
 		fmt.Fprint(os.Stderr, "Please, enter the password\n")
-		os.Exit(1)
+		os.Exit(2)
 	case address == "":
 		fmt.Fprint(os.Stderr, "Please, enter the host\n")
-		os.Exit(1)
+		os.Exit(2)
 	}
-}
-
-func main() {
 
 	// Background context
 	ctx := context.Background()
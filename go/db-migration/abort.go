@@ -0,0 +1,121 @@
+package dbmigration
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+// Abort is closed when the operator asks to stop the migration, either
+// from the terminal UI or with Ctrl-C.
+var (
+	Abort     = make(chan struct{})
+	abortOnce sync.Once
+
+	cleanupOnce        sync.Once
+	temporaryMu        sync.Mutex
+	temporarySnapshots = map[string]*session.Session{}
+	temporaryClusters  = map[string]*session.Session{}
+)
+
+func AbortMigration() {
+	abortOnce.Do(func() { close(Abort) })
+}
+
+// HandleInterrupt turns SIGINT and SIGTERM into an abort so the temporary
+// resources get cleaned up.
+func HandleInterrupt() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		AbortMigration()
+	}()
+}
+
+// Sleep waits before the next poll. If the migration is aborted meanwhile
+// the temporary resources are removed and the program exits.
+func Sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-Abort:
+		StopUI()
+		Log("Migration aborted, cleaning up...")
+		Cleanup()
+		os.Exit(130)
+	}
+}
+
+// Fatal stops the terminal UI before exiting so the terminal is usable.
+func Fatal(v ...interface{}) {
+	StopUI()
+	log.Fatal(v...)
+}
+
+func AddTemporarySnapshot(s string, sess *session.Session) {
+	temporaryMu.Lock()
+	defer temporaryMu.Unlock()
+	temporarySnapshots[s] = sess
+}
+
+func RemoveTemporarySnapshot(s string) {
+	temporaryMu.Lock()
+	defer temporaryMu.Unlock()
+	delete(temporarySnapshots, s)
+}
+
+func AddTemporaryCluster(c string, sess *session.Session) {
+	temporaryMu.Lock()
+	defer temporaryMu.Unlock()
+	temporaryClusters[c] = sess
+}
+
+func RemoveTemporaryCluster(c string) {
+	temporaryMu.Lock()
+	defer temporaryMu.Unlock()
+	delete(temporaryClusters, c)
+}
+
+// Cleanup deletes the temporary clusters and snapshots created so far. A
+// snapshot that is still being created can't be deleted, so it is retried
+// until it is available. Snapshots left behind are tagged with an expiry
+// for the gc command.
+func Cleanup() {
+	cleanupOnce.Do(func() {
+		temporaryMu.Lock()
+		defer temporaryMu.Unlock()
+
+		for c, sess := range temporaryClusters {
+			Log("Deleting temporary cluster: " + c)
+			if _, err := RemoveCluster(c, sess); err != nil {
+				log.Println(err)
+				Log("Cluster " + c + " has been left behind and has to be deleted by hand")
+			}
+			delete(temporaryClusters, c)
+		}
+
+		for s, sess := range temporarySnapshots {
+			Log("Deleting temporary snapshot: " + s)
+			for retries := 0; ; retries++ {
+				_, err := RemoveClusterSnapshot(s, sess)
+				if err == nil {
+					break
+				}
+				if !strings.HasPrefix(err.Error(), rds.ErrCodeInvalidDBClusterSnapshotStateFault) || retries == 60 {
+					log.Println(err)
+					Log("Snapshot " + s + " has been left behind, it will be removed by the gc command once expired")
+					break
+				}
+				time.Sleep(30 * time.Second)
+			}
+			delete(temporarySnapshots, s)
+		}
+	})
+}
@@ -0,0 +1,475 @@
+package dbmigration
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// AuditLogPath is the file where every AWS mutation call is recorded,
+// one JSON document per line. Each entry carries the hash of the previous
+// one so any edit, removal or reordering breaks the chain, and is signed
+// with an HMAC of AuditSigningKey so the chain can't be recomputed without
+// it. The signed sequence number and hash of the last entry are kept next
+// to the log, in its head file, so removing the last entries is detected
+// too.
+var (
+	AuditLogPath    = "db-migration-audit.log"
+	AuditSigningKey = os.Getenv("DB_MIGRATION_AUDIT_KEY")
+	AuditCommand    = "migrate"
+)
+
+type AuditEntry struct {
+	Seq        int64           `json:"seq"`
+	Time       string          `json:"time"`
+	Command    string          `json:"command"`
+	RunID      string          `json:"run_id,omitempty"`
+	Account    string          `json:"account,omitempty"`
+	Caller     string          `json:"caller,omitempty"`
+	Region     string          `json:"region,omitempty"`
+	Service    string          `json:"service,omitempty"`
+	Operation  string          `json:"operation"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Result     string          `json:"result"`
+	Error      string          `json:"error,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash,omitempty"`
+	Signature  string          `json:"signature,omitempty"`
+}
+
+// ErrCodeAuditLog is the error of the requests refused because the audit
+// log can't be written.
+const ErrCodeAuditLog = "AuditLogFailure"
+
+// AuditHead is the last entry of the log, rewritten after every entry.
+type AuditHead struct {
+	Seq       int64  `json:"seq"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature"`
+}
+
+var (
+	auditMu   sync.Mutex
+	auditSeq  int64
+	auditPrev string
+	auditInit bool
+	// The first failure, the log stays broken after it
+	auditErr error
+)
+
+// Parameters whose name contains one of these are never written to disk.
+var redactedParameters = []string{"password", "secret", "token", "presignedurl"}
+
+// CheckAuditKey fails when the signing key of the audit log isn't set.
+func CheckAuditKey() error {
+	if AuditSigningKey == "" {
+		return errors.New("DB_MIGRATION_AUDIT_KEY is required, the audit log is signed with it")
+	}
+	return nil
+}
+
+// AuditHeadPath is the head file of an audit log.
+func AuditHeadPath(path string) string {
+	return path + ".head"
+}
+
+// AttachAudit records every mutating request sent through the session.
+// The caller identity is resolved once with STS GetCallerIdentity.
+func AttachAudit(sess *session.Session) {
+
+	var account, caller string
+
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Println("Unable to resolve caller identity for the audit log:", err)
+	} else {
+		account = aws.StringValue(identity.Account)
+		caller = aws.StringValue(identity.Arn)
+	}
+
+	newEntry := func(r *request.Request) AuditEntry {
+		entry := AuditEntry{
+			Account:   account,
+			Caller:    caller,
+			Region:    aws.StringValue(r.Config.Region),
+			Service:   r.ClientInfo.ServiceName,
+			Operation: r.Operation.Name,
+		}
+		if p, err := redact(r.Params); err == nil {
+			entry.Parameters = p
+		}
+		return entry
+	}
+
+	// The request is recorded before it is signed and sent, it isn't sent
+	// when that fails. Presigned requests are sent by AWS, e.g. for a
+	// cross-region snapshot copy, the call carrying them is recorded.
+	sess.Handlers.Validate.PushBackNamed(request.NamedHandler{
+		Name: "db-migration.AuditRequest",
+		Fn: func(r *request.Request) {
+			if !isMutation(r.Operation.Name) || r.ExpireTime > 0 {
+				return
+			}
+
+			entry := newEntry(r)
+			entry.Result = "requested"
+
+			if err := writeAudit(entry); err != nil {
+				r.Error = awserr.New(ErrCodeAuditLog, "the audit log can't be written, the request isn't sent", err)
+			}
+		},
+	})
+
+	// Its result is recorded once it completes. The call has been made
+	// when that fails, the migration is aborted and, with the audit log
+	// broken, no other call is made.
+	sess.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "db-migration.Audit",
+		Fn: func(r *request.Request) {
+			if !isMutation(r.Operation.Name) {
+				return
+			}
+			if aerr, ok := r.Error.(awserr.Error); ok && aerr.Code() == ErrCodeAuditLog {
+				return
+			}
+
+			entry := newEntry(r)
+			entry.RequestID = r.RequestID
+			entry.Result = "success"
+			if r.Error != nil {
+				entry.Result = "failure"
+				entry.Error = r.Error.Error()
+			}
+
+			if err := writeAudit(entry); err != nil {
+				log.Println("Unable to write audit log, aborting:", err)
+				AbortMigration()
+			}
+		},
+	})
+}
+
+// AuditEvent records an action that isn't an AWS call, e.g. a failed
+// confirmation check.
+func AuditEvent(operation, resource string, err error) {
+
+	entry := AuditEntry{
+		Operation: operation,
+		Result:    "success",
+	}
+	if p, err := json.Marshal(map[string]string{"resource": resource}); err == nil {
+		entry.Parameters = p
+	}
+	if err != nil {
+		entry.Result = "failure"
+		entry.Error = err.Error()
+	}
+
+	if werr := writeAudit(entry); werr != nil {
+		log.Println("Unable to write audit log:", werr)
+	}
+}
+
+func isMutation(op string) bool {
+	for _, p := range []string{"Describe", "List", "Get"} {
+		if strings.HasPrefix(op, p) {
+			return false
+		}
+	}
+	return true
+}
+
+func redact(params interface{}) (json.RawMessage, error) {
+
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(redactValue(v))
+}
+
+func redactValue(v interface{}) interface{} {
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = redactValue(val)
+			for _, r := range redactedParameters {
+				if strings.Contains(strings.ToLower(k), r) {
+					t[k] = "REDACTED"
+				}
+			}
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redactValue(val)
+		}
+	}
+
+	return v
+}
+
+// entryHash covers every field of the entry except the hash and the
+// signature themselves.
+func entryHash(e AuditEntry) (string, error) {
+
+	e.Hash = ""
+	e.Signature = ""
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func entrySignature(hash, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadAuditTail reads the existing log to continue the chain from the
+// last entry.
+func loadAuditTail() error {
+
+	f, err := os.Open(AuditLogPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		auditSeq = e.Seq
+		auditPrev = e.Hash
+	}
+
+	return scanner.Err()
+}
+
+// writeAudit appends an entry to the log. Once an entry couldn't be
+// written the log is broken, an entry written after it would hide the
+// gap, so every later entry fails too.
+func writeAudit(entry AuditEntry) error {
+
+	if err := CheckAuditKey(); err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if auditErr != nil {
+		return errors.New("an earlier entry couldn't be written: " + auditErr.Error())
+	}
+	if err := appendAudit(entry); err != nil {
+		auditErr = err
+		return err
+	}
+
+	return nil
+}
+
+func appendAudit(entry AuditEntry) error {
+
+	if !auditInit {
+		if err := loadAuditTail(); err != nil {
+			return err
+		}
+		auditInit = true
+	}
+
+	entry.Seq = auditSeq + 1
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	entry.Command = AuditCommand
+	entry.RunID = RunID
+	entry.PrevHash = auditPrev
+
+	hash, err := entryHash(entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	entry.Signature = entrySignature(hash, AuditSigningKey)
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(AuditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	auditSeq = entry.Seq
+	auditPrev = entry.Hash
+
+	return writeAuditHead(AuditHeadPath(AuditLogPath), AuditHead{
+		Seq:       entry.Seq,
+		Hash:      entry.Hash,
+		Signature: headSignature(entry.Seq, entry.Hash, AuditSigningKey),
+	})
+}
+
+// headSignature differs from the signature of the entry with the same
+// hash, so an entry signature can't be passed off as a head.
+func headSignature(seq int64, hash, key string) string {
+	return entrySignature(fmt.Sprintf("head:%d:%s", seq, hash), key)
+}
+
+// writeAuditHead replaces the head file in one rename, a crash leaves the
+// previous head.
+func writeAuditHead(path string, head AuditHead) error {
+
+	b, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func readAuditHead(path string) (AuditHead, error) {
+
+	var head AuditHead
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return head, err
+	}
+
+	return head, json.Unmarshal(b, &head)
+}
+
+// VerifyAuditLog walks the chain and returns an error describing the
+// first entry that doesn't match, then checks that the log ends with the
+// entry of its head. The key is required.
+func VerifyAuditLog(path, key string) (int64, error) {
+
+	if key == "" {
+		return 0, errors.New("the signing key is required to verify the audit log")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var (
+		prev  string
+		seq   int64
+		count int64
+	)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return count, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		hash, err := entryHash(e)
+		if err != nil {
+			return count, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		switch {
+		case e.Seq != seq+1:
+			return count, fmt.Errorf("line %d: expected sequence %d, found %d", line, seq+1, e.Seq)
+		case e.PrevHash != prev:
+			return count, fmt.Errorf("line %d: previous hash does not match entry %d", line, seq)
+		case e.Hash != hash:
+			return count, fmt.Errorf("line %d: entry has been modified", line)
+		case !hmac.Equal([]byte(e.Signature), []byte(entrySignature(e.Hash, key))):
+			return count, fmt.Errorf("line %d: invalid signature", line)
+		}
+
+		prev = e.Hash
+		seq = e.Seq
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+
+	head, err := readAuditHead(AuditHeadPath(path))
+	if err != nil {
+		return count, fmt.Errorf("head: %v", err)
+	}
+	switch {
+	case !hmac.Equal([]byte(head.Signature), []byte(headSignature(head.Seq, head.Hash, key))):
+		return count, errors.New("head: invalid signature")
+	case head.Seq > seq:
+		return count, fmt.Errorf("the log ends at entry %d but its head is entry %d, entries have been removed", seq, head.Seq)
+	case head.Seq != seq || head.Hash != prev:
+		return count, fmt.Errorf("the log ends at entry %d but its head is entry %d", seq, head.Seq)
+	}
+
+	return count, nil
+}
+
+// VerifyAudit is the verify-audit command.
+func VerifyAudit(args []string) {
+
+	fs := flag.NewFlagSet("verify-audit", flag.ExitOnError)
+	fs.StringVar(&AuditLogPath, "AuditLog", AuditLogPath, "The audit log file to verify")
+	fs.Parse(args)
+
+	if err := CheckAuditKey(); err != nil {
+		log.Fatal(err)
+	}
+
+	count, err := VerifyAuditLog(AuditLogPath, AuditSigningKey)
+	if err != nil {
+		log.Fatal(errors.New("Audit log " + AuditLogPath + " has been tampered with: " + err.Error()))
+	}
+
+	Log(fmt.Sprintf("Audit log %s verified: %d entries", AuditLogPath, count))
+}
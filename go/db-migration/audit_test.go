@@ -0,0 +1,321 @@
+package dbmigration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+const testAuditKey = "test-key"
+
+// writeTestAudit writes a log of n entries in a temporary directory and
+// returns its path.
+func writeTestAudit(t *testing.T, n int) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	key, logPath := AuditSigningKey, AuditLogPath
+	t.Cleanup(func() {
+		AuditSigningKey, AuditLogPath = key, logPath
+		auditSeq, auditPrev, auditInit, auditErr = 0, "", false, nil
+	})
+	AuditSigningKey, AuditLogPath = testAuditKey, path
+	auditSeq, auditPrev, auditInit, auditErr = 0, "", false, nil
+
+	for i := 0; i < n; i++ {
+		err := writeAudit(AuditEntry{
+			Service:   "rds",
+			Operation: "DeleteDBClusterSnapshot",
+			Result:    "ok",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return path
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func writeLines(t *testing.T, path string, lines []string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		key    string
+		change func(t *testing.T, path string)
+		err    string
+	}{
+		{
+			name: "intact",
+			key:  testAuditKey,
+		},
+		{
+			name: "missing key",
+			err:  "signing key is required",
+		},
+		{
+			name: "wrong key",
+			key:  "other-key",
+			err:  "invalid signature",
+		},
+		{
+			name: "edited entry",
+			key:  testAuditKey,
+			change: func(t *testing.T, path string) {
+				lines := readLines(t, path)
+				lines[1] = strings.Replace(lines[1], `"result":"ok"`, `"result":"failed"`, 1)
+				writeLines(t, path, lines)
+			},
+			err: "line 2: entry has been modified",
+		},
+		{
+			name: "reordered entries",
+			key:  testAuditKey,
+			change: func(t *testing.T, path string) {
+				lines := readLines(t, path)
+				lines[1], lines[2] = lines[2], lines[1]
+				writeLines(t, path, lines)
+			},
+			err: "line 2: expected sequence 2, found 3",
+		},
+		{
+			name: "removed entry",
+			key:  testAuditKey,
+			change: func(t *testing.T, path string) {
+				lines := readLines(t, path)
+				writeLines(t, path, append(lines[:1], lines[2:]...))
+			},
+			err: "line 2: expected sequence 2, found 3",
+		},
+		{
+			name: "truncated log",
+			key:  testAuditKey,
+			change: func(t *testing.T, path string) {
+				writeLines(t, path, readLines(t, path)[:2])
+			},
+			err: "entries have been removed",
+		},
+		{
+			name: "missing head",
+			key:  testAuditKey,
+			change: func(t *testing.T, path string) {
+				if err := os.Remove(AuditHeadPath(path)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "head",
+		},
+		{
+			name: "forged head",
+			key:  testAuditKey,
+			change: func(t *testing.T, path string) {
+				writeLines(t, path, readLines(t, path)[:2])
+				head, err := readAuditHead(AuditHeadPath(path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				head.Seq = 2
+				if err := writeAuditHead(AuditHeadPath(path), head); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err: "head: invalid signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestAudit(t, 4)
+			if tt.change != nil {
+				tt.change(t, path)
+			}
+
+			count, err := VerifyAuditLog(path, tt.key)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err == "" && count != 4:
+				t.Fatalf("verified %d entries, want 4", count)
+			case tt.err != "" && err == nil:
+				t.Fatalf("no error, want %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWriteAuditRequiresKey(t *testing.T) {
+
+	path := writeTestAudit(t, 0)
+	AuditSigningKey = ""
+
+	if err := writeAudit(AuditEntry{Operation: "DeleteDBCluster"}); err == nil {
+		t.Fatal("entry written without a signing key")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("audit log created without a signing key: %v", err)
+	}
+}
+
+func TestAuditLogContinuesChain(t *testing.T) {
+
+	path := writeTestAudit(t, 2)
+
+	// A new process reads the tail of the existing log
+	auditSeq, auditPrev, auditInit = 0, "", false
+	if err := writeAudit(AuditEntry{Operation: "DeleteDBCluster"}); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := VerifyAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("verified %d entries, want 3", count)
+	}
+}
+
+// auditSession returns a session sending its requests to a stand-in for
+// AWS, with the actions it received.
+func auditSession(t *testing.T) (*session.Session, func() []string) {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		actions []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		actions = append(actions, r.Form.Get("Action"))
+		mu.Unlock()
+		fmt.Fprint(w, "<Response></Response>")
+	}))
+	t.Cleanup(ts.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-2"),
+		Endpoint:    aws.String(ts.URL),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AttachAudit(sess)
+
+	return sess, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, actions...)
+	}
+}
+
+func TestAuditRecordsMutations(t *testing.T) {
+
+	path := writeTestAudit(t, 0)
+	sess, actions := auditSession(t)
+	svc := rds.New(sess)
+
+	if _, err := svc.DescribeDBClusters(&rds.DescribeDBClustersInput{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := svc.DeleteDBClusterSnapshot(&rds.DeleteDBClusterSnapshotInput{
+		DBClusterSnapshotIdentifier: aws.String("migrationsnapshot-1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"GetCallerIdentity", "DescribeDBClusters", "DeleteDBClusterSnapshot"}
+	if got := actions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("actions = %v, want %v", got, want)
+	}
+
+	// The request before it is sent, then its result
+	var results []string
+	for _, l := range readLines(t, path) {
+		var e AuditEntry
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, e.Operation+" "+e.Result)
+	}
+	want = []string{"DeleteDBClusterSnapshot requested", "DeleteDBClusterSnapshot success"}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("entries = %v, want %v", results, want)
+	}
+
+	if _, err := VerifyAuditLog(path, testAuditKey); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuditRefusesUnrecordedMutations(t *testing.T) {
+
+	path := writeTestAudit(t, 0)
+	sess, actions := auditSession(t)
+	svc := rds.New(sess)
+
+	// The directory of the log doesn't exist
+	AuditLogPath = filepath.Join(t.TempDir(), "missing", "audit.log")
+
+	_, err := svc.DeleteDBClusterSnapshot(&rds.DeleteDBClusterSnapshotInput{
+		DBClusterSnapshotIdentifier: aws.String("migrationsnapshot-1"),
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != ErrCodeAuditLog {
+		t.Fatalf("error = %v, want %s", err, ErrCodeAuditLog)
+	}
+
+	// The log stays broken once an entry is missing
+	AuditLogPath = path
+	_, err = svc.DeleteDBClusterSnapshot(&rds.DeleteDBClusterSnapshotInput{
+		DBClusterSnapshotIdentifier: aws.String("migrationsnapshot-1"),
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != ErrCodeAuditLog {
+		t.Fatalf("error = %v, want %s", err, ErrCodeAuditLog)
+	}
+
+	// Reads still go through
+	if _, err := svc.DescribeDBClusters(&rds.DescribeDBClustersInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"GetCallerIdentity", "DescribeDBClusters"}
+	if got := actions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+}
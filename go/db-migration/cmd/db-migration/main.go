@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	dbmigration "db-migration-v2"
+)
+
+func main() {
+	dbmigration.Main(os.Args[1:])
+}
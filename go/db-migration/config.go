@@ -0,0 +1,242 @@
+package dbmigration
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A config file holds migration flags by name, shared by every profile
+// under defaults and overridden per profile:
+//
+//	defaults:
+//	  SourceProfileRegion: eu-west-2
+//	profiles:
+//	  gitea-prod:
+//	    SourceClusterName: gitea
+//
+// Values are resolved in this order, the last one wins: flag default,
+// config defaults, config profile, environment variable, command line.
+// Config, ConfigProfile and ShowConfig choose the file, they only come
+// from the command line or the environment.
+var (
+	ConfigPath    string
+	ConfigProfile string
+	ShowConfig    bool
+)
+
+var configFlags = []string{"Config", "ConfigProfile", "ShowConfig"}
+
+func isConfigFlag(name string) bool {
+	for _, f := range configFlags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+type ConfigFile struct {
+	Defaults map[string]string            `yaml:"defaults"`
+	Profiles map[string]map[string]string `yaml:"profiles"`
+}
+
+// RequiredMigrationFlags must have a value once everything is merged.
+var RequiredMigrationFlags = []string{
+	"SourceClusterName",
+	"MigrationKeyAlias",
+	"DestinationAccountID",
+	"DestinationKMSKeyAlias",
+	"DestinationClusterEngine",
+	"DestinationClusterEngineVersion",
+	"DestinationClusterSubnetGroup",
+	"DestinationClusterSecurityGroup",
+}
+
+var (
+	accountIDPattern   = regexp.MustCompile(`^[0-9]{12}$`)
+	placeholderPattern = regexp.MustCompile(`<[^>]*>`)
+)
+
+// EnvName returns the environment variable overriding a flag, e.g.
+// DestinationAccountID is DB_MIGRATION_DESTINATION_ACCOUNT_ID.
+func EnvName(flagName string) string {
+
+	var b strings.Builder
+	r := []rune(flagName)
+
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) {
+			prevLower := unicode.IsLower(r[i-1])
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1]) && unicode.IsUpper(r[i-1])
+			if prevLower || nextLower {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(c))
+	}
+
+	return "DB_MIGRATION_" + b.String()
+}
+
+// LoadConfig parses the command line and merges the config file and the
+// environment into the flags that weren't given. It returns where each
+// value came from. Commands other than the migration share the profiles
+// but aren't strict, keys they don't know about are skipped.
+func LoadConfig(fs *flag.FlagSet, args []string, strict bool) (map[string]string, error) {
+
+	fs.StringVar(&ConfigPath, "Config", ConfigPath, "A YAML file with migration parameters grouped in profiles")
+	fs.StringVar(&ConfigProfile, "ConfigProfile", ConfigProfile, "The profile of the config file to use, e.g. gitea-prod")
+	fs.BoolVar(&ShowConfig, "ShowConfig", ShowConfig, "Print the merged configuration and exit")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	sources := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) { sources[f.Name] = "default" })
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+		sources[f.Name] = "command line"
+	})
+
+	setEnv := func(name string) error {
+		v, ok := os.LookupEnv(EnvName(name))
+		if !ok || explicit[name] {
+			return nil
+		}
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %v", EnvName(name), name, err)
+		}
+		sources[name] = "env " + EnvName(name)
+		return nil
+	}
+
+	// Before the file they choose is read
+	for _, name := range configFlags {
+		if err := setEnv(name); err != nil {
+			return nil, err
+		}
+	}
+
+	apply := func(values map[string]string, source string) error {
+		var keys []string
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if isConfigFlag(k) {
+				return fmt.Errorf("%s: %s can't be set in the config file", source, k)
+			}
+			if fs.Lookup(k) == nil {
+				if !strict {
+					continue
+				}
+				return fmt.Errorf("%s: unknown parameter %s", source, k)
+			}
+			if explicit[k] {
+				continue
+			}
+			if err := fs.Set(k, values[k]); err != nil {
+				return fmt.Errorf("%s: invalid value for %s: %v", source, k, err)
+			}
+			sources[k] = source
+		}
+		return nil
+	}
+
+	if ConfigPath != "" {
+		b, err := os.ReadFile(ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var cfg ConfigFile
+		if err := yaml.Unmarshal(b, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %v", ConfigPath, err)
+		}
+
+		if err := apply(cfg.Defaults, ConfigPath+" defaults"); err != nil {
+			return nil, err
+		}
+
+		if ConfigProfile != "" {
+			values, ok := cfg.Profiles[ConfigProfile]
+			if !ok {
+				var names []string
+				for n := range cfg.Profiles {
+					names = append(names, n)
+				}
+				sort.Strings(names)
+				return nil, fmt.Errorf("%s: profile %s not found, available profiles: %s", ConfigPath, ConfigProfile, strings.Join(names, ", "))
+			}
+			if err := apply(values, ConfigPath+" profile "+ConfigProfile); err != nil {
+				return nil, err
+			}
+		}
+	} else if ConfigProfile != "" {
+		return nil, errors.New("ConfigProfile needs a Config file")
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if envErr != nil || isConfigFlag(f.Name) {
+			return
+		}
+		envErr = setEnv(f.Name)
+	})
+
+	return sources, envErr
+}
+
+// ValidateMigrationConfig reports every missing or malformed value at once,
+// with where the value came from.
+func ValidateMigrationConfig(fs *flag.FlagSet, sources map[string]string) error {
+
+	var problems []string
+
+	for _, name := range RequiredMigrationFlags {
+		if fs.Lookup(name).Value.String() == "" {
+			problems = append(problems, fmt.Sprintf("%s is required (flag --%s, config key %s or env %s)", name, name, name, EnvName(name)))
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if placeholderPattern.MatchString(f.Value.String()) {
+			problems = append(problems, fmt.Sprintf("%s still contains the placeholder %q (from %s)", f.Name, f.Value.String(), sources[f.Name]))
+		}
+	})
+
+	if id := DestinationAccountID; id != "" && !placeholderPattern.MatchString(id) && !accountIDPattern.MatchString(id) {
+		problems = append(problems, fmt.Sprintf("DestinationAccountID %q is not a 12 digit account ID (from %s)", id, sources["DestinationAccountID"]))
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// PrintConfig writes the merged configuration with the source of each value.
+func PrintConfig(w io.Writer, fs *flag.FlagSet, sources map[string]string) {
+	fs.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "Config", "ConfigProfile", "ShowConfig":
+			return
+		}
+		fmt.Fprintf(w, "%-38s %-28s # %s\n", f.Name, f.Value.String(), sources[f.Name])
+	})
+}
@@ -0,0 +1,200 @@
+package dbmigration
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+
+	tests := map[string]string{
+		"Owner":                         "DB_MIGRATION_OWNER",
+		"SourceClusterName":             "DB_MIGRATION_SOURCE_CLUSTER_NAME",
+		"DestinationAccountID":          "DB_MIGRATION_DESTINATION_ACCOUNT_ID",
+		"SnapshotTTL":                   "DB_MIGRATION_SNAPSHOT_TTL",
+		"DestinationWriterInstanceType": "DB_MIGRATION_DESTINATION_WRITER_INSTANCE_TYPE",
+	}
+
+	for flagName, want := range tests {
+		if got := EnvName(flagName); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	const file = `
+defaults:
+  SourceClusterName: from-defaults
+  SourceProfileRegion: eu-west-1
+profiles:
+  gitea:
+    SourceClusterName: from-profile
+`
+
+	tests := []struct {
+		name    string
+		file    string
+		args    []string
+		env     map[string]string
+		strict  bool
+		values  map[string]string
+		sources map[string]string
+		err     string
+	}{
+		{
+			name:    "defaults",
+			values:  map[string]string{"SourceClusterName": "", "SourceProfileRegion": "eu-west-2", "SnapshotTTL": "72h0m0s"},
+			sources: map[string]string{"SourceClusterName": "default", "SourceProfileRegion": "default", "SnapshotTTL": "default"},
+		},
+		{
+			name:    "config defaults",
+			file:    file,
+			args:    []string{"--Config=$CONFIG"},
+			values:  map[string]string{"SourceClusterName": "from-defaults", "SourceProfileRegion": "eu-west-1"},
+			sources: map[string]string{"SourceClusterName": "$CONFIG defaults", "Config": "command line"},
+		},
+		{
+			name:    "config profile",
+			file:    file,
+			args:    []string{"--Config=$CONFIG", "--ConfigProfile=gitea"},
+			values:  map[string]string{"SourceClusterName": "from-profile", "SourceProfileRegion": "eu-west-1"},
+			sources: map[string]string{"SourceClusterName": "$CONFIG profile gitea", "SourceProfileRegion": "$CONFIG defaults"},
+		},
+		{
+			name:    "environment over the file",
+			file:    file,
+			args:    []string{"--Config=$CONFIG", "--ConfigProfile=gitea"},
+			env:     map[string]string{"DB_MIGRATION_SOURCE_CLUSTER_NAME": "from-env"},
+			values:  map[string]string{"SourceClusterName": "from-env"},
+			sources: map[string]string{"SourceClusterName": "env DB_MIGRATION_SOURCE_CLUSTER_NAME"},
+		},
+		{
+			name:    "command line over the environment",
+			file:    file,
+			args:    []string{"--Config=$CONFIG", "--SourceClusterName=from-flag", "--SnapshotTTL=1h"},
+			env:     map[string]string{"DB_MIGRATION_SOURCE_CLUSTER_NAME": "from-env", "DB_MIGRATION_SNAPSHOT_TTL": "2h"},
+			values:  map[string]string{"SourceClusterName": "from-flag", "SnapshotTTL": "1h0m0s"},
+			sources: map[string]string{"SourceClusterName": "command line", "SnapshotTTL": "command line"},
+		},
+		{
+			name:    "file and profile from the environment",
+			file:    file,
+			env:     map[string]string{"DB_MIGRATION_CONFIG": "$CONFIG", "DB_MIGRATION_CONFIG_PROFILE": "gitea"},
+			values:  map[string]string{"SourceClusterName": "from-profile", "SourceProfileRegion": "eu-west-1"},
+			sources: map[string]string{"SourceClusterName": "$CONFIG profile gitea", "Config": "env DB_MIGRATION_CONFIG", "ConfigProfile": "env DB_MIGRATION_CONFIG_PROFILE"},
+		},
+		{
+			name:    "file flag over the environment",
+			file:    file,
+			args:    []string{"--Config=$CONFIG"},
+			env:     map[string]string{"DB_MIGRATION_CONFIG": "/missing.yaml"},
+			values:  map[string]string{"SourceClusterName": "from-defaults"},
+			sources: map[string]string{"Config": "command line"},
+		},
+		{
+			name: "invalid environment value",
+			env:  map[string]string{"DB_MIGRATION_SNAPSHOT_TTL": "3 days"},
+			err:  `DB_MIGRATION_SNAPSHOT_TTL: invalid value for SnapshotTTL: parse error`,
+		},
+		{
+			name: "invalid file value",
+			file: "defaults:\n  SnapshotTTL: soon\n",
+			args: []string{"--Config=$CONFIG"},
+			err:  "$CONFIG defaults: invalid value for SnapshotTTL",
+		},
+		{
+			name: "ConfigProfile without Config",
+			args: []string{"--ConfigProfile=gitea"},
+			err:  "ConfigProfile needs a Config file",
+		},
+		{
+			name: "ConfigProfile from the environment without Config",
+			env:  map[string]string{"DB_MIGRATION_CONFIG_PROFILE": "gitea"},
+			err:  "ConfigProfile needs a Config file",
+		},
+		{
+			name: "unknown profile",
+			file: file,
+			args: []string{"--Config=$CONFIG", "--ConfigProfile=wiki"},
+			err:  "profile wiki not found, available profiles: gitea",
+		},
+		{
+			name:   "unknown key",
+			file:   "defaults:\n  Region: eu-west-1\n",
+			args:   []string{"--Config=$CONFIG"},
+			strict: true,
+			err:    "$CONFIG defaults: unknown parameter Region",
+		},
+		{
+			name:   "unknown key of another command",
+			file:   "defaults:\n  Region: eu-west-1\n",
+			args:   []string{"--Config=$CONFIG"},
+			values: map[string]string{"SourceProfileRegion": "eu-west-2"},
+		},
+		{
+			name: "config file chosen in the file",
+			file: "defaults:\n  ConfigProfile: gitea\n",
+			args: []string{"--Config=$CONFIG"},
+			err:  "$CONFIG defaults: ConfigProfile can't be set in the config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db-migration.yaml")
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expand := func(s string) string { return strings.ReplaceAll(s, "$CONFIG", path) }
+
+			for k, v := range tt.env {
+				t.Setenv(k, expand(v))
+			}
+			config, profile, show := ConfigPath, ConfigProfile, ShowConfig
+			defer func() { ConfigPath, ConfigProfile, ShowConfig = config, profile, show }()
+
+			fs := flag.NewFlagSet("db-migration", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			fs.String("SourceClusterName", "", "")
+			fs.String("SourceProfileRegion", "eu-west-2", "")
+			fs.Duration("SnapshotTTL", 72*time.Hour, "")
+
+			var args []string
+			for _, a := range tt.args {
+				args = append(args, expand(a))
+			}
+
+			sources, err := LoadConfig(fs, args, tt.strict)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("no error, want %q", expand(tt.err))
+			case tt.err != "" && !strings.Contains(err.Error(), expand(tt.err)):
+				t.Fatalf("error %q, want %q", err, expand(tt.err))
+			case tt.err != "":
+				return
+			}
+
+			for k, want := range tt.values {
+				if got := fs.Lookup(k).Value.String(); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+			for k, want := range tt.sources {
+				if got := sources[k]; got != expand(want) {
+					t.Errorf("source of %s = %q, want %q", k, got, expand(want))
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,10 @@
-package main
+package dbmigration
 
 import (
 	"errors"
 	"flag"
 	"log"
+	"os"
 	"sync"
 	"time"
 
@@ -144,19 +145,54 @@ func GetClusterSnapshot(s, t string, sess *session.Session) (*rds.DescribeDBClus
 		input  *rds.DescribeDBClusterSnapshotsInput
 	)
 
+	// Snapshot identifiers are unique per account, the cluster identifier
+	// can't be combined with them in the same request.
 	svc := rds.New(sess)
 	if t == "" {
 		input = &rds.DescribeDBClusterSnapshotsInput{
 			DBClusterSnapshotIdentifier: aws.String(s),
 			SnapshotType:                aws.String("manual"),
-			DBClusterIdentifier:         aws.String(SourceClusterName),
 		}
 	} else if t == "shared" {
 		input = &rds.DescribeDBClusterSnapshotsInput{
 			DBClusterSnapshotIdentifier: aws.String(s),
 			SnapshotType:                aws.String(t), // Use "shared" instead
-			DBClusterIdentifier:         aws.String(SourceClusterName),
 		}
+	} else if t == "any" {
+		// Without a type both manual and automated snapshots are returned
+		input = &rds.DescribeDBClusterSnapshotsInput{
+			DBClusterSnapshotIdentifier: aws.String(s),
+		}
+	}
+
+	result, err := svc.DescribeDBClusterSnapshots(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case rds.ErrCodeDBClusterSnapshotNotFoundFault:
+				return result, errors.New(rds.ErrCodeDBClusterSnapshotNotFoundFault + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func GetManualClusterSnapshots(m string, sess *session.Session) (*rds.DescribeDBClusterSnapshotsOutput, error) {
+	var result *rds.DescribeDBClusterSnapshotsOutput
+
+	svc := rds.New(sess)
+	input := &rds.DescribeDBClusterSnapshotsInput{
+		SnapshotType: aws.String("manual"),
+	}
+	if m != "" {
+		input.Marker = aws.String(m)
 	}
 
 	result, err := svc.DescribeDBClusterSnapshots(input)
@@ -178,13 +214,14 @@ func GetClusterSnapshot(s, t string, sess *session.Session) (*rds.DescribeDBClus
 	return result, nil
 }
 
-func CreateClusterSnapshot(c, s string, sess *session.Session) (*rds.CreateDBClusterSnapshotOutput, error) {
+func CreateClusterSnapshot(c, s string, t []*rds.Tag, sess *session.Session) (*rds.CreateDBClusterSnapshotOutput, error) {
 	var result *rds.CreateDBClusterSnapshotOutput
 
 	svc := rds.New(sess)
 	input := &rds.CreateDBClusterSnapshotInput{
 		DBClusterIdentifier:         aws.String(c),
 		DBClusterSnapshotIdentifier: aws.String(s),
+		Tags:                        t,
 	}
 
 	result, err := svc.CreateDBClusterSnapshot(input)
@@ -214,7 +251,7 @@ func CreateClusterSnapshot(c, s string, sess *session.Session) (*rds.CreateDBClu
 	return result, nil
 }
 
-func CopyClusterSnapshot(s, t, k string, sess *session.Session) (*rds.CopyDBClusterSnapshotOutput, error) {
+func CopyClusterSnapshot(s, t, k string, tags []*rds.Tag, sess *session.Session) (*rds.CopyDBClusterSnapshotOutput, error) {
 	var result *rds.CopyDBClusterSnapshotOutput
 
 	svc := rds.New(sess)
@@ -224,6 +261,7 @@ func CopyClusterSnapshot(s, t, k string, sess *session.Session) (*rds.CopyDBClus
 		KmsKeyId:                          aws.String("alias/" + k),
 		SourceRegion:                      aws.String(SourceProfileRegion),
 		DestinationRegion:                 aws.String(DestinationProfileRegion),
+		Tags:                              tags,
 	}
 
 	input.SetDestinationRegion(DestinationProfileRegion)
@@ -291,7 +329,7 @@ func ShareClusterSnapshot(s, id string, sess *session.Session) (*rds.ModifyDBClu
 	return result, nil
 }
 
-func CreateClusterFromSnapshot(sess *session.Session) (*rds.RestoreDBClusterFromSnapshotOutput, error) {
+func CreateClusterFromSnapshot(t []*rds.Tag, sess *session.Session) (*rds.RestoreDBClusterFromSnapshotOutput, error) {
 	var result *rds.RestoreDBClusterFromSnapshotOutput
 
 	svc := rds.New(sess)
@@ -299,14 +337,17 @@ func CreateClusterFromSnapshot(sess *session.Session) (*rds.RestoreDBClusterFrom
 		DBClusterIdentifier: aws.String(DestinationClusterName),
 		Engine:              aws.String(DestinationClusterEngine),
 		EngineVersion:       aws.String(DestinationClusterEngineVersion),
-		EngineMode:          aws.String(DestinationClusterEngineMode),
+		EngineMode:          aws.String(EngineMode()),
 		DBSubnetGroupName:   aws.String(DestinationClusterSubnetGroup),
 		DeletionProtection:  aws.Bool(true),
 		KmsKeyId:            aws.String("alias/" + DestinationKMSKeyAlias),
 		VpcSecurityGroupIds: []*string{
 			aws.String(DestinationClusterSecurityGroup),
 		},
-		SnapshotIdentifier: aws.String(MigrationSnapshotARN),
+		SnapshotIdentifier:               aws.String(MigrationSnapshotARN),
+		ScalingConfiguration:             ScalingConfiguration(),
+		ServerlessV2ScalingConfiguration: ServerlessV2ScalingConfiguration(),
+		Tags:                             t,
 	}
 
 	result, err := svc.RestoreDBClusterFromSnapshot(input)
@@ -361,6 +402,65 @@ func CreateClusterFromSnapshot(sess *session.Session) (*rds.RestoreDBClusterFrom
 	return result, nil
 }
 
+func RestoreClusterToPointInTime(c *rds.DBCluster, t string, rt time.Time, tags []*rds.Tag, sess *session.Session) (*rds.RestoreDBClusterToPointInTimeOutput, error) {
+	var result *rds.RestoreDBClusterToPointInTimeOutput
+
+	svc := rds.New(sess)
+	input := &rds.RestoreDBClusterToPointInTimeInput{
+		SourceDBClusterIdentifier: c.DBClusterIdentifier,
+		DBClusterIdentifier:       aws.String(t),
+		RestoreToTime:             aws.Time(rt),
+		DBSubnetGroupName:         c.DBSubnetGroup,
+		DeletionProtection:        aws.Bool(false),
+		Tags:                      tags,
+	}
+	for _, sg := range c.VpcSecurityGroups {
+		input.VpcSecurityGroupIds = append(input.VpcSecurityGroupIds, sg.VpcSecurityGroupId)
+	}
+
+	result, err := svc.RestoreDBClusterToPointInTime(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case rds.ErrCodeDBClusterAlreadyExistsFault:
+				return result, errors.New(rds.ErrCodeDBClusterAlreadyExistsFault + aerr.Error())
+			case rds.ErrCodeDBClusterNotFoundFault:
+				return result, errors.New(rds.ErrCodeDBClusterNotFoundFault + aerr.Error())
+			case rds.ErrCodeDBClusterQuotaExceededFault:
+				return result, errors.New(rds.ErrCodeDBClusterQuotaExceededFault + aerr.Error())
+			case rds.ErrCodeStorageQuotaExceededFault:
+				return result, errors.New(rds.ErrCodeStorageQuotaExceededFault + aerr.Error())
+			case rds.ErrCodeDBSubnetGroupNotFoundFault:
+				return result, errors.New(rds.ErrCodeDBSubnetGroupNotFoundFault + aerr.Error())
+			case rds.ErrCodeInsufficientDBClusterCapacityFault:
+				return result, errors.New(rds.ErrCodeInsufficientDBClusterCapacityFault + aerr.Error())
+			case rds.ErrCodeInsufficientStorageClusterCapacityFault:
+				return result, errors.New(rds.ErrCodeInsufficientStorageClusterCapacityFault + aerr.Error())
+			case rds.ErrCodeInvalidDBClusterSnapshotStateFault:
+				return result, errors.New(rds.ErrCodeInvalidDBClusterSnapshotStateFault + aerr.Error())
+			case rds.ErrCodeInvalidDBClusterStateFault:
+				return result, errors.New(rds.ErrCodeInvalidDBClusterStateFault + aerr.Error())
+			case rds.ErrCodeInvalidRestoreFault:
+				return result, errors.New(rds.ErrCodeInvalidRestoreFault + aerr.Error())
+			case rds.ErrCodeInvalidSubnet:
+				return result, errors.New(rds.ErrCodeInvalidSubnet + aerr.Error())
+			case rds.ErrCodeInvalidVPCNetworkStateFault:
+				return result, errors.New(rds.ErrCodeInvalidVPCNetworkStateFault + aerr.Error())
+			case rds.ErrCodeKMSKeyNotAccessibleFault:
+				return result, errors.New(rds.ErrCodeKMSKeyNotAccessibleFault + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
 func SetCluster(c string, sess *session.Session) (*rds.ModifyDBClusterOutput, error) {
 	var result *rds.ModifyDBClusterOutput
 
@@ -443,7 +543,7 @@ func RemoveClusterSnapshot(s string, sess *session.Session) (*rds.DeleteDBCluste
 	return result, nil
 }
 
-func CreateClusterInstance(n, t string, sess *session.Session) (*rds.CreateDBInstanceOutput, error) {
+func CreateClusterInstance(n, t string, tags []*rds.Tag, sess *session.Session) (*rds.CreateDBInstanceOutput, error) {
 	var result *rds.CreateDBInstanceOutput
 
 	svc := rds.New(sess)
@@ -452,6 +552,7 @@ func CreateClusterInstance(n, t string, sess *session.Session) (*rds.CreateDBIns
 		DBInstanceClass:      aws.String(t),
 		DBInstanceIdentifier: aws.String(n),
 		Engine:               aws.String(DestinationClusterEngine),
+		Tags:                 tags,
 	}
 
 	result, err := svc.CreateDBInstance(input)
@@ -671,10 +772,111 @@ func GetClusterInstance(n string, sess *session.Session) (*rds.DescribeDBInstanc
 	return result, nil
 }
 
+func DisableClusterDeletionProtection(c string, sess *session.Session) (*rds.ModifyDBClusterOutput, error) {
+	var result *rds.ModifyDBClusterOutput
+
+	svc := rds.New(sess)
+	input := &rds.ModifyDBClusterInput{
+		ApplyImmediately:    aws.Bool(true),
+		DBClusterIdentifier: aws.String(c),
+		DeletionProtection:  aws.Bool(false),
+	}
+
+	result, err := svc.ModifyDBCluster(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case rds.ErrCodeDBClusterNotFoundFault:
+				return result, errors.New(rds.ErrCodeDBClusterNotFoundFault + aerr.Error())
+			case rds.ErrCodeInvalidDBClusterStateFault:
+				return result, errors.New(rds.ErrCodeInvalidDBClusterStateFault + aerr.Error())
+			case rds.ErrCodeInvalidDBInstanceStateFault:
+				return result, errors.New(rds.ErrCodeInvalidDBInstanceStateFault + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func RemoveClusterInstance(i string, sess *session.Session) (*rds.DeleteDBInstanceOutput, error) {
+	var result *rds.DeleteDBInstanceOutput
+
+	svc := rds.New(sess)
+	input := &rds.DeleteDBInstanceInput{
+		DBInstanceIdentifier: aws.String(i),
+	}
+
+	result, err := svc.DeleteDBInstance(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case rds.ErrCodeDBInstanceNotFoundFault:
+				return result, errors.New(rds.ErrCodeDBInstanceNotFoundFault + aerr.Error())
+			case rds.ErrCodeInvalidDBInstanceStateFault:
+				return result, errors.New(rds.ErrCodeInvalidDBInstanceStateFault + aerr.Error())
+			case rds.ErrCodeInvalidDBClusterStateFault:
+				return result, errors.New(rds.ErrCodeInvalidDBClusterStateFault + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
+func RemoveCluster(c string, sess *session.Session) (*rds.DeleteDBClusterOutput, error) {
+	var result *rds.DeleteDBClusterOutput
+
+	svc := rds.New(sess)
+	input := &rds.DeleteDBClusterInput{
+		DBClusterIdentifier: aws.String(c),
+		SkipFinalSnapshot:   aws.Bool(true),
+	}
+
+	result, err := svc.DeleteDBCluster(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case rds.ErrCodeDBClusterNotFoundFault:
+				return result, errors.New(rds.ErrCodeDBClusterNotFoundFault + aerr.Error())
+			case rds.ErrCodeInvalidDBClusterStateFault:
+				return result, errors.New(rds.ErrCodeInvalidDBClusterStateFault + aerr.Error())
+			case rds.ErrCodeInvalidDBClusterSnapshotStateFault:
+				return result, errors.New(rds.ErrCodeInvalidDBClusterSnapshotStateFault + aerr.Error())
+			default:
+				return result, errors.New(aerr.Error())
+			}
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			return result, errors.New(err.Error())
+		}
+	}
+
+	return result, nil
+}
+
 func Log(m string) {
 	log.Println(m)
 }
 
+const (
+	DefaultWriterInstanceType = "db.r5.2xlarge"
+	DefaultReaderInstanceType = "db.r5.xlarge"
+)
+
 var (
 	SourceClusterName                    string
 	DestinationClusterName               string
@@ -687,8 +889,8 @@ var (
 	DestinationKMSKeyAlias               string
 	DestinationClusterWriterInstanceName string = "writer"
 	DestinationClusterReaderInstanceName string = "reader"
-	DestinationWriterInstanceType        string = "db.r5.2xlarge"
-	DestinationReaderInstanceType        string = "db.r5.xlarge"
+	DestinationWriterInstanceType        string = DefaultWriterInstanceType
+	DestinationReaderInstanceType        string = DefaultReaderInstanceType
 	DestinationClusterEngine             string
 	DestinationClusterEngineVersion      string
 	DestinationClusterEngineMode         string = "serverless"
@@ -699,86 +901,141 @@ var (
 )
 
 var (
-	ClusterSnapshotName     = "migrationsnapshot-" + time.Now().Format("11063912340")
-	ClusterSnapshotCopyName = "migrationsnapshotshared-" + time.Now().Format("11063912340")
+	RunID                   = runID()
+	ClusterSnapshotName     = "migrationsnapshot-" + RunID
+	ClusterSnapshotCopyName = "migrationsnapshotshared-" + RunID
 )
 
-func main() {
+// MigrationFlags registers the flags of the migration command. The server
+// uses them too to validate the migration specs it receives.
+func MigrationFlags(fs *flag.FlagSet) {
+	fs.StringVar(&SourceClusterName, "SourceClusterName", SourceClusterName, "Specify the name of the cluster to migrate.")
+	fs.StringVar(&DestinationClusterName, "DestinationClusterName", DestinationClusterName, "Enter the name that will belong to the cluster created in the destination account, defaults to SourceClusterName.")
+	fs.StringVar(&MigrationKeyAlias, "MigrationKeyAlias", MigrationKeyAlias, "The name of the key used to share the snapshot with the destination account.")
+	fs.StringVar(&SourceProfile, "SourceProfile", SourceProfile, "The name of the profile with access to the source db cluster account")
+	fs.StringVar(&DestinationProfile, "DestinationProfile", DestinationProfile, "The name of the profile with access to the destination db cluster account")
+	fs.StringVar(&DestinationKMSKeyAlias, "DestinationKMSKeyAlias", DestinationKMSKeyAlias, "The alias of the key that will be used to encrypt the db cluster in the destination account")
+	fs.StringVar(&DestinationClusterWriterInstanceName, "DestinationClusterWriterInstanceName", DestinationClusterWriterInstanceName, "The name of the  writer instnace that will be part of the migrated cluster in the destination account")
+	fs.StringVar(&DestinationClusterReaderInstanceName, "DestinationClusterReaderInstanceName", DestinationClusterReaderInstanceName, "The name of the reader instnace that will be part of the migrated cluster in the destination account")
+	fs.StringVar(&DestinationWriterInstanceType, "DestinationWriterInstanceType", DestinationWriterInstanceType, "The instance type of the db cluster writer instance in the destination account")
+	fs.StringVar(&DestinationReaderInstanceType, "DestinationReaderInstanceType", DestinationReaderInstanceType, "The instance type of the db cluster reader instances in the destination account")
+	fs.StringVar(&DestinationAccountID, "DestinationAccountID", DestinationAccountID, "The ID of the account where the db will be migrated")
+	fs.StringVar(&DestinationClusterEngine, "DestinationClusterEngine", DestinationClusterEngine, "The destination cluster engine version")
+	fs.StringVar(&DestinationClusterEngineMode, "DestinationClusterEngineMode", DestinationClusterEngineMode, "The destination cluster engine mode: provisioned, serverless or serverlessv2")
+	fs.StringVar(&DestinationClusterEngineVersion, "DestinationClusterEngineVersion", DestinationClusterEngineVersion, "The destination cluster engine version")
+	fs.StringVar(&DestinationClusterSubnetGroup, "DestinationClusterSubnetGroup", DestinationClusterSubnetGroup, "The VPC rds subnets group where the cluster should be placed")
+	fs.StringVar(&DestinationClusterSecurityGroup, "DestinationClusterSecurityGroup", DestinationClusterSecurityGroup, "The security group to be assosiated with the destination cluster")
+	fs.StringVar(&ClusterAdministratorUserName, "ClusterAdministratorUserName", ClusterAdministratorUserName, "The admin user name of the db cluster that will be migrated")
+	fs.StringVar(&SourceProfileRegion, "SourceProfileRegion", SourceProfileRegion, "Specify the region where the db is located.")
+	fs.StringVar(&DestinationProfileRegion, "DestinationProfileRegion", DestinationProfileRegion, "Specify the region where the db is going to be migrated.")
+	SessionFlags(fs)
+	fs.StringVar(&AuditLogPath, "AuditLog", AuditLogPath, "The file where every AWS mutation call is recorded")
+	fs.StringVar(&Owner, "Owner", Owner, "The owner tagged on every resource created by the migration")
+	fs.DurationVar(&SnapshotTTL, "SnapshotTTL", SnapshotTTL, "How long temporary snapshots are kept before the gc command deletes them")
+	fs.BoolVar(&UI, "UI", UI, "Show the live progress of each phase in a terminal UI")
+	fs.StringVar(&FromSnapshot, "FromSnapshot", FromSnapshot, "Migrate from this existing manual or automated snapshot instead of creating a new one")
+	fs.Float64Var(&MinCapacity, "MinCapacity", MinCapacity, "The minimum capacity in ACUs of a serverless or serverlessv2 destination cluster")
+	fs.Float64Var(&MaxCapacity, "MaxCapacity", MaxCapacity, "The maximum capacity in ACUs of a serverless or serverlessv2 destination cluster")
+	fs.BoolVar(&AutoPause, "AutoPause", AutoPause, "Pause a serverless destination cluster when it is idle")
+	fs.Int64Var(&SecondsUntilAutoPause, "SecondsUntilAutoPause", SecondsUntilAutoPause, "Idle time in seconds before a serverless destination cluster is paused")
+	fs.StringVar(&TimeoutAction, "TimeoutAction", TimeoutAction, "What a serverless destination cluster does when a scaling point isn't found: RollbackCapacityChange or ForceApplyCapacityChange")
+	fs.Int64Var(&SecondsBeforeTimeout, "SecondsBeforeTimeout", SecondsBeforeTimeout, "How long a serverless destination cluster looks for a scaling point before the timeout action")
+	fs.StringVar(&RestoreTime, "RestoreTime", RestoreTime, "Migrate the source cluster as it was at this time (RFC3339), using a temporary point-in-time restore")
+
+}
+
+// Main runs the migration, or the command named by the first argument.
+func Main(args []string) {
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "decommission":
+			Decommission(args[1:])
+			return
+		case "verify-audit":
+			VerifyAudit(args[1:])
+			return
+		case "gc":
+			GC(args[1:])
+			return
+		case "serve":
+			Serve(args[1:])
+			return
+		}
+	}
 
 	start := time.Now()
 	var msg string
 	var wg sync.WaitGroup
 
-	flag.StringVar(&SourceClusterName, "SourceClusterName", SourceClusterName, "Specify the name of the cluster to migrate.")
-	flag.StringVar(&DestinationClusterName, "DestinationClusterName", SourceClusterName, "Enter the name that will belong to the cluster created in the destination account.")
-	flag.StringVar(&MigrationKeyAlias, "MigrationKeyAlias", MigrationKeyAlias, "The name of the key used to share the snapshot with the destination account.")
-	flag.StringVar(&SourceProfile, "SourceProfile", SourceProfile, "The name of the profile with access to the source db cluster account")
-	flag.StringVar(&DestinationProfile, "DestinationProfile", DestinationProfile, "The name of the profile with access to the destination db cluster account")
-	flag.StringVar(&DestinationKMSKeyAlias, "DestinationKMSKeyAlias", DestinationKMSKeyAlias, "The alias of the key that will be used to encrypt the db cluster in the destination account")
-	flag.StringVar(&DestinationClusterWriterInstanceName, "DestinationClusterWriterInstanceName", DestinationClusterWriterInstanceName, "The name of the  writer instnace that will be part of the migrated cluster in the destination account")
-	flag.StringVar(&DestinationClusterReaderInstanceName, "DestinationClusterReaderInstanceName", DestinationClusterReaderInstanceName, "The name of the reader instnace that will be part of the migrated cluster in the destination account")
-	flag.StringVar(&DestinationWriterInstanceType, "DestinationWriterInstanceType", DestinationWriterInstanceType, "The instance type of the db cluster writer instance in the destination account")
-	flag.StringVar(&DestinationReaderInstanceType, "DestinationReaderInstanceType", DestinationReaderInstanceType, "The instance type of the db cluster reader instances in the destination account")
-	flag.StringVar(&DestinationAccountID, "DestinationAccountID", DestinationAccountID, "The ID of the account where the db will be migrated")
-	flag.StringVar(&DestinationClusterEngine, "DestinationClusterEngine", DestinationClusterEngine, "The destination cluster engine version")
-	flag.StringVar(&DestinationClusterEngineMode, "DestinationClusterEngineMode", DestinationClusterEngineMode, "The destination cluster engine mode")
-	flag.StringVar(&DestinationClusterEngineVersion, "DestinationClusterEngineVersion", DestinationClusterEngineVersion, "The destination cluster engine version")
-	flag.StringVar(&DestinationClusterSubnetGroup, "DestinationClusterSubnetGroup", DestinationClusterSubnetGroup, "The VPC rds subnets group where the cluster should be placed")
-	flag.StringVar(&DestinationClusterSecurityGroup, "DestinationClusterSecurityGroup", DestinationClusterSecurityGroup, "The security group to be assosiated with the destination cluster")
-	flag.StringVar(&ClusterAdministratorUserName, "ClusterAdministratorUserName", ClusterAdministratorUserName, "The admin user name of the db cluster that will be migrated")
-	flag.StringVar(&SourceProfileRegion, "SourceProfileRegion", SourceProfileRegion, "Specify the region where the db is located.")
-	flag.StringVar(&DestinationProfileRegion, "DestinationProfileRegion", DestinationProfileRegion, "Specify the region where the db is going to be migrated.")
-
-	flag.Parse()
-
-	SourceSession := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Profile:           SourceProfile,
-		Config: aws.Config{
-			Region: aws.String(SourceProfileRegion),
-		},
-	}))
-
-	DestinationSession := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Profile:           DestinationProfile,
-		Config: aws.Config{
-			Region: aws.String(DestinationProfileRegion),
-		},
-	}))
+	fs := flag.NewFlagSet("db-migration", flag.ExitOnError)
+	MigrationFlags(fs)
+	sources, err := LoadConfig(fs, args, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if DestinationClusterName == "" {
+		DestinationClusterName = SourceClusterName
+		sources["DestinationClusterName"] = "SourceClusterName"
+	}
+	if ShowConfig {
+		PrintConfig(os.Stdout, fs, sources)
+		return
+	}
+	if err := ValidateMigrationConfig(fs, sources); err != nil {
+		log.Fatal(err)
+	}
 
-	// Create cluster snapshot from source cluster
-	Log("Creating db cluster snapshot: " + ClusterSnapshotName)
-	_, err := CreateClusterSnapshot(SourceClusterName, ClusterSnapshotName, SourceSession)
+	rt, err := ValidateSource()
 	if err != nil {
 		log.Fatal(err)
 	}
-	Log("Wait until Snapshot is completed...")
-	for status := false; !status; {
-		time.Sleep(1 * time.Minute)
-		result, err := GetClusterSnapshot(ClusterSnapshotName, "", SourceSession)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if *result.DBClusterSnapshots[0].Status == "available" {
-			status = true
-		}
+	if err := ValidateScaling(); err != nil {
+		log.Fatal(err)
 	}
-	Log("Cluster snapshot successfully created")
+	ApplyServerlessDefaults()
+
+	SourceSession := NewSession(SourceConfig())
+	DestinationSession := NewSession(DestinationConfig())
+
+	// The snapshot is shared with DestinationAccountID
+	if err := CheckAccount("DestinationAccountID", DestinationAccountID, DestinationSession); err != nil {
+		log.Fatal(err)
+	}
+
+	HandleInterrupt()
+	StartUI([]string{
+		"Prepare source snapshot",
+		"Copy snapshot with migration key",
+		"Share snapshot",
+		"Restore cluster in destination",
+		"Create cluster instances",
+	})
+	defer StopUI()
+
+	Log("Migration run ID: " + RunID)
+	expires := start.Add(SnapshotTTL)
 
+	// Create cluster snapshot from source cluster
+	SetPhase(0)
+	SourceSnapshotName, temporary := PrepareSourceSnapshot(rt, expires, SourceSession)
+
+	SetPhase(1)
 	Log("Copying snapshot with new KMS key: " + MigrationKeyAlias)
-	_, err = CopyClusterSnapshot(ClusterSnapshotName, ClusterSnapshotCopyName, MigrationKeyAlias, SourceSession)
+	_, err = CopyClusterSnapshot(SourceSnapshotName, ClusterSnapshotCopyName, MigrationKeyAlias, MigrationTags(expires), SourceSession)
 	if err != nil {
-		log.Fatal(err)
+		Fatal(err)
 	}
+	AddTemporarySnapshot(ClusterSnapshotCopyName, SourceSession)
 
 	Log("Wait until Snapshot is completed...")
 	for status := false; !status; {
-		time.Sleep(1 * time.Minute)
+		Sleep(1 * time.Minute)
 		result, err := GetClusterSnapshot(ClusterSnapshotCopyName, "", SourceSession)
 		if err != nil {
-			log.Fatal(err)
+			Fatal(err)
 		}
+		Progress(ClusterSnapshotCopyName, *result.DBClusterSnapshots[0].Status, aws.Int64Value(result.DBClusterSnapshots[0].PercentProgress))
 		if *result.DBClusterSnapshots[0].Status == "available" {
 			status = true
 		}
@@ -786,38 +1043,44 @@ func main() {
 
 	Log("Cluster snapshot copy successfully created")
 
-	_, err = RemoveClusterSnapshot(ClusterSnapshotName, SourceSession)
-	if err != nil {
-		log.Fatal(err)
+	if temporary {
+		_, err = RemoveClusterSnapshot(SourceSnapshotName, SourceSession)
+		if err != nil {
+			Fatal(err)
+		}
+		RemoveTemporarySnapshot(SourceSnapshotName)
 	}
 
+	SetPhase(2)
 	Log("Sharing snapshot with destination account: " + DestinationAccountID)
 	_, err = ShareClusterSnapshot(ClusterSnapshotCopyName, DestinationAccountID, SourceSession)
 	if err != nil {
-		log.Fatal(err)
+		Fatal(err)
 	}
 
 	// Get shared snapshot
 
 	s, err := GetClusterSnapshot(ClusterSnapshotCopyName, "", SourceSession)
 	if err != nil {
-		log.Fatal(err)
+		Fatal(err)
 	}
 	MigrationSnapshotARN = *s.DBClusterSnapshots[0].DBClusterSnapshotArn
 
+	SetPhase(3)
 	Log("Creating cluster " + DestinationClusterName + " in destination account " + DestinationAccountID)
-	_, err = CreateClusterFromSnapshot(DestinationSession)
+	_, err = CreateClusterFromSnapshot(MigrationTags(time.Time{}), DestinationSession)
 	if err != nil {
-		log.Fatal(err)
+		Fatal(err)
 	}
 
 	Log("Wait untill cluster is ready...")
 	for status := false; !status; {
-		time.Sleep(1 * time.Minute)
+		Sleep(1 * time.Minute)
 		result, err := GetCluster(DestinationClusterName, DestinationSession)
 		if err != nil {
-			log.Fatal(err)
+			Fatal(err)
 		}
+		Progress(DestinationClusterName, *result.DBClusters[0].Status, -1)
 
 		if *result.DBClusters[0].Status == "available" {
 			status = true
@@ -828,28 +1091,33 @@ func main() {
 
 	_, err = RemoveClusterSnapshot(ClusterSnapshotCopyName, SourceSession)
 	if err != nil {
-		log.Fatal(err)
+		Fatal(err)
 	}
+	RemoveTemporarySnapshot(ClusterSnapshotCopyName)
 
 	msg = "ready"
 
-	if msg == "ready" && DestinationClusterEngineMode != "serverless" {
+	// Serverless v1 clusters have no instances, Serverless v2 ones get
+	// db.serverless instances
+	if msg == "ready" && DestinationClusterEngineMode != EngineModeServerless {
+		SetPhase(4)
 		wg.Add(2)
 		go func() {
 
 			Log("Creating Writer instances: " + DestinationClusterWriterInstanceName)
-			_, err := CreateClusterInstance(DestinationClusterWriterInstanceName, DestinationWriterInstanceType, DestinationSession)
+			_, err := CreateClusterInstance(DestinationClusterWriterInstanceName, DestinationWriterInstanceType, MigrationTags(time.Time{}), DestinationSession)
 			if err != nil {
-				log.Fatal(err)
+				Fatal(err)
 			}
 
 			Log("Wait for Writer instance to be ready...")
 			for status := false; !status; {
-				time.Sleep(1 * time.Minute)
+				Sleep(1 * time.Minute)
 				result, err := GetClusterInstance(DestinationClusterWriterInstanceName, DestinationSession)
 				if err != nil {
-					log.Fatal(err)
+					Fatal(err)
 				}
+				Progress(DestinationClusterWriterInstanceName, *result.DBInstances[0].DBInstanceStatus, -1)
 				if *result.DBInstances[0].DBInstanceStatus == "available" {
 					status = true
 				}
@@ -862,17 +1130,18 @@ func main() {
 		go func() {
 			time.Sleep(5 * time.Millisecond)
 			Log("Creating Reader instance: " + DestinationClusterReaderInstanceName)
-			_, err := CreateClusterInstance(DestinationClusterReaderInstanceName, DestinationReaderInstanceType, DestinationSession)
+			_, err := CreateClusterInstance(DestinationClusterReaderInstanceName, DestinationReaderInstanceType, MigrationTags(time.Time{}), DestinationSession)
 			if err != nil {
-				log.Fatal(err)
+				Fatal(err)
 			}
 			Log("Wait for Reader instance to be ready...")
 			for status := false; !status; {
-				time.Sleep(1 * time.Minute)
+				Sleep(1 * time.Minute)
 				result, err := GetClusterInstance(DestinationClusterReaderInstanceName, DestinationSession)
 				if err != nil {
-					log.Fatal(err)
+					Fatal(err)
 				}
+				Progress(DestinationClusterReaderInstanceName, *result.DBInstances[0].DBInstanceStatus, -1)
 				if *result.DBInstances[0].DBInstanceStatus == "available" {
 					status = true
 				}
@@ -886,6 +1155,21 @@ func main() {
 	}
 
 	wg.Wait()
+	SetPhase(5)
 	Log("Migration Completed")
 	Log("Total migration time: " + time.Since(start).String())
+
+	source, err := GetCluster(SourceClusterName, SourceSession)
+	if err != nil {
+		Fatal(err)
+	}
+	destination, err := GetCluster(DestinationClusterName, DestinationSession)
+	if err != nil {
+		Fatal(err)
+	}
+	token, err := DecommissionToken(source.DBClusters[0], destination.DBClusters[0], AuditSigningKey)
+	if err != nil {
+		Fatal(err)
+	}
+	Log("Decommission token: " + token)
 }
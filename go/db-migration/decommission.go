@@ -0,0 +1,233 @@
+package dbmigration
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+var (
+	ConfirmationToken     string
+	SnapshotRetentionDays = 30
+	// WaitTimeout bounds each wait of the decommission: the final snapshot,
+	// the deletion of the instances and of the cluster.
+	WaitTimeout = 2 * time.Hour
+)
+
+// DecommissionToken ties the source cluster to the cluster created by a
+// migration run. The destination ARN, creation time and the run ID tagged
+// on it make it unique to that run, so a token can't be reused against a
+// different destination. It is an HMAC of the audit key: the ARNs and the
+// run ID can be read by anyone with access to the accounts, the key can't.
+func DecommissionToken(source, destination *rds.DBCluster, key string) (string, error) {
+
+	runID := clusterTag(destination, TagRunID)
+	if runID == "" {
+		return "", errors.New("Cluster " + aws.StringValue(destination.DBClusterIdentifier) + " has no " + TagRunID + " tag, it wasn't created by a migration run")
+	}
+
+	var created string
+	if destination.ClusterCreateTime != nil {
+		created = destination.ClusterCreateTime.UTC().Format(time.RFC3339)
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.Join([]string{
+		"decommission",
+		aws.StringValue(source.DBClusterArn),
+		DestinationAccountID,
+		aws.StringValue(destination.DBClusterArn),
+		created,
+		runID,
+	}, "|")))
+
+	return hex.EncodeToString(mac.Sum(nil))[:32], nil
+}
+
+func clusterTag(c *rds.DBCluster, key string) string {
+	for _, t := range c.TagList {
+		if aws.StringValue(t.Key) == key {
+			return aws.StringValue(t.Value)
+		}
+	}
+	return ""
+}
+
+// waitUntil calls done every interval until it returns true, and fails
+// once WaitTimeout has passed.
+func waitUntil(what string, interval time.Duration, done func() (bool, error)) error {
+
+	start := time.Now()
+	for {
+		time.Sleep(interval)
+		ok, err := done()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Since(start) >= WaitTimeout {
+			return errors.New("Timed out after " + time.Since(start).Round(time.Second).String() + " waiting for " + what)
+		}
+	}
+}
+
+// Decommission deletes the source cluster once the migrated cluster has
+// been verified. A final snapshot tagged with its retention period is
+// taken before anything is removed.
+func Decommission(args []string) {
+
+	start := time.Now()
+	AuditCommand = "decommission"
+
+	fs := flag.NewFlagSet(AuditCommand, flag.ExitOnError)
+	fs.StringVar(&SourceClusterName, "SourceClusterName", SourceClusterName, "The name of the source cluster to decommission.")
+	fs.StringVar(&SourceProfile, "SourceProfile", SourceProfile, "The name of the profile with access to the source db cluster account")
+	fs.StringVar(&SourceProfileRegion, "SourceProfileRegion", SourceProfileRegion, "Specify the region where the source db is located.")
+	fs.StringVar(&DestinationClusterName, "DestinationClusterName", DestinationClusterName, "The name of the migrated cluster in the destination account.")
+	fs.StringVar(&DestinationProfile, "DestinationProfile", DestinationProfile, "The name of the profile with access to the destination db cluster account")
+	fs.StringVar(&DestinationProfileRegion, "DestinationProfileRegion", DestinationProfileRegion, "Specify the region where the db was migrated.")
+	fs.StringVar(&DestinationAccountID, "DestinationAccountID", DestinationAccountID, "The ID of the account where the db was migrated")
+	SessionFlags(fs)
+	fs.StringVar(&ConfirmationToken, "ConfirmationToken", ConfirmationToken, "The decommission token printed at the end of the migration run")
+	fs.IntVar(&SnapshotRetentionDays, "SnapshotRetentionDays", SnapshotRetentionDays, "Number of days the final snapshot of the source cluster should be kept")
+	fs.DurationVar(&WaitTimeout, "WaitTimeout", WaitTimeout, "How long to wait for the final snapshot, the deletion of the instances and of the cluster, each")
+	fs.StringVar(&AuditLogPath, "AuditLog", AuditLogPath, "The file where every AWS mutation call is recorded")
+	fs.StringVar(&Owner, "Owner", Owner, "The owner tagged on the final snapshot")
+	if _, err := LoadConfig(fs, args, false); err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case SourceClusterName == "":
+		log.Fatal("Please, enter the SourceClusterName")
+	case DestinationClusterName == "":
+		log.Fatal("Please, enter the DestinationClusterName")
+	case DestinationAccountID == "":
+		log.Fatal("Please, enter the DestinationAccountID")
+	case ConfirmationToken == "":
+		log.Fatal("Please, enter the ConfirmationToken printed by the migration run")
+	}
+
+	SourceSession := NewSession(SourceConfig())
+	DestinationSession := NewSession(DestinationConfig())
+
+	// The token is issued for DestinationAccountID
+	if err := CheckAccount("DestinationAccountID", DestinationAccountID, DestinationSession); err != nil {
+		log.Fatal(err)
+	}
+
+	// Verify the destination before touching the source
+	source, err := GetCluster(SourceClusterName, SourceSession)
+	if err != nil {
+		log.Fatal(err)
+	}
+	destination, err := GetCluster(DestinationClusterName, DestinationSession)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *destination.DBClusters[0].Status != "available" {
+		log.Fatal("Destination cluster " + DestinationClusterName + " is " + *destination.DBClusters[0].Status + ", expected available")
+	}
+	token, err := DecommissionToken(source.DBClusters[0], destination.DBClusters[0], AuditSigningKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !hmac.Equal([]byte(token), []byte(ConfirmationToken)) {
+		AuditEvent("VerifyConfirmationToken", SourceClusterName, os.ErrPermission)
+		log.Fatal("Confirmation token does not match the migration of " + SourceClusterName + " to " + DestinationClusterName)
+	}
+	AuditEvent("VerifyConfirmationToken", SourceClusterName, nil)
+
+	// Final snapshot of the source cluster
+	FinalSnapshotName := "decommissionsnapshot-" + SourceClusterName + "-" + start.UTC().Format("20060102150405")
+	tags := append(MigrationTags(start.AddDate(0, 0, SnapshotRetentionDays)),
+		&rds.Tag{Key: aws.String("retention-days"), Value: aws.String(strconv.Itoa(SnapshotRetentionDays))},
+		&rds.Tag{Key: aws.String("migrated-to"), Value: aws.String(DestinationAccountID + "/" + DestinationClusterName)},
+	)
+
+	Log("Creating final db cluster snapshot: " + FinalSnapshotName)
+	_, err = CreateClusterSnapshot(SourceClusterName, FinalSnapshotName, tags, SourceSession)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Log("Wait until Snapshot is completed...")
+	err = waitUntil("snapshot "+FinalSnapshotName, time.Minute, func() (bool, error) {
+		result, err := GetClusterSnapshot(FinalSnapshotName, "", SourceSession)
+		if err != nil {
+			return false, err
+		}
+		return *result.DBClusterSnapshots[0].Status == "available", nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	Log("Final snapshot successfully created")
+
+	Log("Disabling deletion protection on cluster " + SourceClusterName)
+	_, err = DisableClusterDeletionProtection(SourceClusterName, SourceSession)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, m := range source.DBClusters[0].DBClusterMembers {
+		Log("Deleting instance: " + *m.DBInstanceIdentifier)
+		_, err = RemoveClusterInstance(*m.DBInstanceIdentifier, SourceSession)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	Log("Wait until instances are deleted...")
+	for _, m := range source.DBClusters[0].DBClusterMembers {
+		err := waitUntil("the deletion of instance "+*m.DBInstanceIdentifier, time.Minute, func() (bool, error) {
+			_, err := GetClusterInstance(*m.DBInstanceIdentifier, SourceSession)
+			if err != nil {
+				if !strings.HasPrefix(err.Error(), rds.ErrCodeDBInstanceNotFoundFault) {
+					return false, err
+				}
+				return true, nil
+			}
+			return false, nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	Log("Deleting cluster: " + SourceClusterName)
+	_, err = RemoveCluster(SourceClusterName, SourceSession)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Log("Wait until cluster is deleted...")
+	err = waitUntil("the deletion of cluster "+SourceClusterName, time.Minute, func() (bool, error) {
+		_, err := GetCluster(SourceClusterName, SourceSession)
+		if err != nil {
+			if !strings.HasPrefix(err.Error(), rds.ErrCodeDBClusterNotFoundFault) {
+				return false, err
+			}
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	Log("Cluster " + SourceClusterName + " successfully decommissioned")
+	Log("Final snapshot " + FinalSnapshotName + " kept for " + strconv.Itoa(SnapshotRetentionDays) + " days")
+	Log("Total decommission time: " + time.Since(start).String())
+}
@@ -2,6 +2,17 @@ module db-migration-v2
 
 go 1.17
 
-require github.com/aws/aws-sdk-go v1.44.0
+require (
+	awssession v0.0.0-00010101000000-000000000000
+	github.com/aws/aws-sdk-go v1.44.0
+	go.etcd.io/bbolt v1.3.8
+	golang.org/x/term v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
-require github.com/jmespath/go-jmespath v0.4.0 // indirect
+require (
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+)
+
+replace awssession => ../awssession
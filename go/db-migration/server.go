@@ -0,0 +1,597 @@
+package dbmigration
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The API only listens on the loopback interface unless told otherwise,
+// and always requires a token: DB_MIGRATION_API_TOKEN, or one per user in
+// DB_MIGRATION_API_TOKENS as user=token pairs separated by commas.
+var (
+	Listen    = "127.0.0.1:8080"
+	DataDir   = "db-migration-data"
+	APIToken  = os.Getenv("DB_MIGRATION_API_TOKEN")
+	APITokens = os.Getenv("DB_MIGRATION_API_TOKENS")
+)
+
+// The user submitting runs with DB_MIGRATION_API_TOKEN
+const defaultTokenUser = "api-token"
+
+// ParseTokens returns the API tokens by user.
+func ParseTokens(token, tokens string) (map[string]string, error) {
+
+	users := map[string]string{}
+
+	if token != "" {
+		users[defaultTokenUser] = token
+	}
+	for _, t := range strings.Split(tokens, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		i := strings.Index(t, "=")
+		if i <= 0 || i == len(t)-1 {
+			return nil, errors.New("DB_MIGRATION_API_TOKENS: " + t + " is not user=token")
+		}
+		users[t[:i]] = t[i+1:]
+	}
+
+	return users, nil
+}
+
+// Authenticate returns the user of the bearer token of a request, or ""
+// when there is none or it matches no token. Tokens are compared in
+// constant time.
+func Authenticate(r *http.Request, tokens map[string]string) string {
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	given := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	for u, t := range tokens {
+		if subtle.ConstantTimeCompare(given, []byte(t)) == 1 {
+			return u
+		}
+	}
+
+	return ""
+}
+
+const (
+	RunRunning     = "running"
+	RunSucceeded   = "succeeded"
+	RunFailed      = "failed"
+	RunCancelled   = "cancelled"
+	RunInterrupted = "interrupted"
+)
+
+var runsBucket = []byte("runs")
+
+// Run is a migration submitted through the API. The spec holds the
+// migration flags, e.g. {"SourceClusterName": "gitea"}.
+type Run struct {
+	ID          string            `json:"id"`
+	Spec        map[string]string `json:"spec"`
+	Status      string            `json:"status"`
+	SubmittedBy string            `json:"submitted_by,omitempty"`
+	// The X-Forwarded-User header of the submission, set by whatever is in
+	// front of the API and not verified by it
+	ForwardedUser string     `json:"forwarded_user_unverified,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// Flags the server sets itself for every run.
+var reservedSpecFlags = map[string]bool{"UI": true, "AuditLog": true}
+
+var requiredSpecFlags = []string{
+	"SourceClusterName",
+	"SourceProfile",
+	"DestinationProfile",
+	"DestinationAccountID",
+	"MigrationKeyAlias",
+	"DestinationKMSKeyAlias",
+}
+
+type Server struct {
+	db      *bolt.DB
+	dataDir string
+	tokens  map[string]string
+
+	mu        sync.Mutex
+	running   map[string]*exec.Cmd
+	cancelled map[string]bool
+	// The goroutines waiting for the runs
+	wg sync.WaitGroup
+}
+
+// RunConfig is a validated spec: the migration flags it sets, with their
+// values as the migration parses them.
+type RunConfig struct {
+	Flags map[string]string
+}
+
+// Args returns the flags of the run, in a stable order.
+func (c RunConfig) Args() []string {
+
+	var args []string
+	for k, v := range c.Flags {
+		args = append(args, "--"+k+"="+v)
+	}
+	sort.Strings(args)
+
+	return args
+}
+
+var (
+	migrationFlagsOnce sync.Once
+	migrationFlagSet   *flag.FlagSet
+)
+
+// specFlags returns the flags of the migration bound to values of their
+// own. MigrationFlags binds them to the package variables, which the
+// concurrent submissions of the server would share.
+func specFlags() *flag.FlagSet {
+
+	migrationFlagsOnce.Do(func() {
+		migrationFlagSet = flag.NewFlagSet("migrate", flag.ContinueOnError)
+		MigrationFlags(migrationFlagSet)
+	})
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	migrationFlagSet.VisitAll(func(f *flag.Flag) {
+		switch f.Value.(flag.Getter).Get().(type) {
+		case string:
+			fs.String(f.Name, f.DefValue, f.Usage)
+		case bool:
+			v, _ := strconv.ParseBool(f.DefValue)
+			fs.Bool(f.Name, v, f.Usage)
+		case float64:
+			v, _ := strconv.ParseFloat(f.DefValue, 64)
+			fs.Float64(f.Name, v, f.Usage)
+		case int64:
+			v, _ := strconv.ParseInt(f.DefValue, 10, 64)
+			fs.Int64(f.Name, v, f.Usage)
+		case time.Duration:
+			v, _ := time.ParseDuration(f.DefValue)
+			fs.Duration(f.Name, v, f.Usage)
+		default:
+			panic("unsupported type of migration flag " + f.Name)
+		}
+	})
+
+	return fs
+}
+
+// ValidateSpec checks the spec against the flags of the migration command.
+func ValidateSpec(spec map[string]string) (RunConfig, error) {
+
+	c := RunConfig{Flags: map[string]string{}}
+	fs := specFlags()
+
+	for k, v := range spec {
+		if reservedSpecFlags[k] {
+			return c, errors.New(k + " is set by the server")
+		}
+		if fs.Lookup(k) == nil {
+			return c, errors.New("unknown migration parameter " + k)
+		}
+		if err := fs.Set(k, v); err != nil {
+			return c, errors.New("invalid value for " + k + ": " + err.Error())
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) { c.Flags[f.Name] = f.Value.String() })
+
+	for _, k := range requiredSpecFlags {
+		if c.Flags[k] == "" {
+			return c, errors.New(k + " is required")
+		}
+	}
+
+	return c, nil
+}
+
+func NewServer(dataDir string) (*Server, error) {
+
+	if err := os.MkdirAll(filepath.Join(dataDir, "runs"), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filepath.Join(dataDir, "runs.db"), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		db:        db,
+		dataDir:   dataDir,
+		running:   map[string]*exec.Cmd{},
+		cancelled: map[string]bool{},
+	}
+
+	// Runs that were going on when the server stopped have lost their
+	// process, their state in AWS has to be checked by hand.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var r Run
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if r.Status != RunRunning {
+				return nil
+			}
+			r.Status = RunInterrupted
+			r.Error = "the server stopped while the migration was running"
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			return b.Put(k, data)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Server) save(r *Run) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(runsBucket).Put([]byte(r.ID), data)
+	})
+}
+
+func (s *Server) get(id string) (*Run, error) {
+
+	var r *Run
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(runsBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		r = &Run{}
+		return json.Unmarshal(v, r)
+	})
+
+	return r, err
+}
+
+func (s *Server) list() ([]*Run, error) {
+
+	var runs []*Run
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(runsBucket).ForEach(func(k, v []byte) error {
+			r := &Run{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+			runs = append(runs, r)
+			return nil
+		})
+	})
+
+	// Run IDs start with a timestamp, newest first
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+
+	return runs, err
+}
+
+func (s *Server) logPath(id string) string {
+	return filepath.Join(s.dataDir, "runs", id+".log")
+}
+
+// ExecArgs are the arguments that run a migration with this binary,
+// before the migration flags. Binaries embedding the migration as a
+// subcommand set them to the path of that subcommand.
+var ExecArgs []string
+
+// execCommand starts the runs, tests replace it.
+var execCommand = exec.Command
+
+// start runs the migration as a child process of this same binary, so
+// each run has its own state and can be cancelled with a signal. The run
+// is a copy, updated and saved once the process exits, the caller's
+// stays as it was submitted.
+func (s *Server) start(r Run, c RunConfig) error {
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	args := append(append([]string{}, ExecArgs...), "--AuditLog="+filepath.Join(s.dataDir, "runs", r.ID+".audit.log"))
+	args = append(args, c.Args()...)
+
+	logFile, err := os.OpenFile(s.logPath(r.ID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	cmd := execCommand(exe, args...)
+	cmd.Env = append(os.Environ(), "DB_MIGRATION_RUN_ID="+r.ID)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.running[r.ID] = cmd
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		err := cmd.Wait()
+		logFile.Close()
+
+		s.mu.Lock()
+		cancelled := s.cancelled[r.ID]
+		delete(s.running, r.ID)
+		delete(s.cancelled, r.ID)
+		s.mu.Unlock()
+
+		now := time.Now().UTC()
+		r.FinishedAt = &now
+		switch {
+		case cancelled:
+			r.Status = RunCancelled
+		case err != nil:
+			r.Status = RunFailed
+			r.Error = err.Error()
+		default:
+			r.Status = RunSucceeded
+		}
+
+		if err := s.save(&r); err != nil {
+			log.Println("Unable to save run", r.ID, err)
+		}
+		Log("Run " + r.ID + " " + r.Status)
+	}()
+
+	return nil
+}
+
+// cancel sends SIGTERM, the migration then removes its temporary
+// snapshots before exiting.
+func (s *Server) cancel(id string) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd, ok := s.running[id]
+	if !ok {
+		return false
+	}
+	s.cancelled[id] = true
+	cmd.Process.Signal(syscall.SIGTERM)
+
+	return true
+}
+
+func (s *Server) isRunning(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.running[id]
+	return ok
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	user := Authenticate(r, s.tokens)
+	if user == "" {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+		return
+	}
+
+	// /runs, /runs/{id}, /runs/{id}/log, /runs/{id}/cancel
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "runs" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.handleList(w, r)
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.handleSubmit(w, r, user)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.handleGet(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "log" && r.Method == http.MethodGet:
+		s.handleLog(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "cancel" && r.Method == http.MethodPost:
+		s.handleCancel(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.list()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request, user string) {
+
+	var spec map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	config, err := ValidateSpec(spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	run := Run{
+		ID:            NewRunID(),
+		Spec:          spec,
+		Status:        RunRunning,
+		SubmittedBy:   user,
+		ForwardedUser: r.Header.Get("X-Forwarded-User"),
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	if err := s.save(&run); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := s.start(run, config); err != nil {
+		run.Status = RunFailed
+		run.Error = err.Error()
+		s.save(&run)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	Log("Run " + run.ID + " submitted by " + run.SubmittedBy + " from " + r.RemoteAddr + " for cluster " + spec["SourceClusterName"])
+	writeJSON(w, http.StatusCreated, run)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, id string) {
+	run, err := s.get(id)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	case run == nil:
+		writeError(w, http.StatusNotFound, errors.New("run "+id+" not found"))
+	default:
+		writeJSON(w, http.StatusOK, run)
+	}
+}
+
+// handleLog streams the log of a run. With ?follow=true the response
+// stays open until the run finishes.
+func (s *Server) handleLog(w http.ResponseWriter, r *http.Request, id string) {
+
+	run, err := s.get(id)
+	if err != nil || run == nil {
+		writeError(w, http.StatusNotFound, errors.New("run "+id+" not found"))
+		return
+	}
+
+	f, err := os.Open(s.logPath(id))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	defer f.Close()
+
+	follow := r.URL.Query().Get("follow") == "true"
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	for {
+		// Check before copying so the last lines written by the run
+		// are sent before returning
+		done := !s.isRunning(id)
+
+		if _, err := io.Copy(w, f); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !follow || done {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.cancel(id) {
+		writeError(w, http.StatusConflict, errors.New("run "+id+" is not running"))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": "cancelling"})
+}
+
+// Serve is the serve command.
+func Serve(args []string) {
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&Listen, "Listen", Listen, "The address the HTTP API listens on")
+	fs.StringVar(&DataDir, "DataDir", DataDir, "The directory where run state and logs are kept")
+	fs.Parse(args)
+
+	tokens, err := ParseTokens(APIToken, APITokens)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(tokens) == 0 {
+		log.Fatal("DB_MIGRATION_API_TOKEN or DB_MIGRATION_API_TOKENS is required, the API starts migrations with the credentials of the server")
+	}
+	// Every run needs it for its audit log
+	if err := CheckAuditKey(); err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := NewServer(DataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.tokens = tokens
+	defer s.db.Close()
+
+	Log("Listening on " + Listen)
+	log.Fatal(http.ListenAndServe(Listen, s))
+}
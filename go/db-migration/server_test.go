@@ -0,0 +1,310 @@
+package dbmigration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseTokens(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		token  string
+		tokens string
+		want   map[string]string
+		err    bool
+	}{
+		{"none", "", "", map[string]string{}, false},
+		{"single token", "s3cret", "", map[string]string{defaultTokenUser: "s3cret"}, false},
+		{"per user", "", "alice=a1, bob=b2,", map[string]string{"alice": "a1", "bob": "b2"}, false},
+		{"both", "s3cret", "alice=a1", map[string]string{defaultTokenUser: "s3cret", "alice": "a1"}, false},
+		{"token with =", "", "alice=a=1", map[string]string{"alice": "a=1"}, false},
+		{"no user", "", "=a1", nil, true},
+		{"no token", "", "alice=", nil, true},
+		{"no separator", "", "alice", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTokens(tt.token, tt.tokens)
+			if (err != nil) != tt.err {
+				t.Fatalf("ParseTokens() error = %v, want error %v", err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTokens() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+
+	tokens := map[string]string{"alice": "a1", "bob": "b2"}
+
+	tests := []struct {
+		name   string
+		header string
+		user   string
+	}{
+		{"alice", "Bearer a1", "alice"},
+		{"bob", "Bearer b2", "bob"},
+		{"no header", "", ""},
+		{"unknown token", "Bearer c3", ""},
+		{"no scheme", "a1", ""},
+		{"other scheme", "Basic a1", ""},
+		{"lower case scheme", "bearer a1", ""},
+		{"token prefix", "Bearer a", ""},
+		{"empty token", "Bearer ", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/runs", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if got := Authenticate(r, tokens); got != tt.user {
+				t.Errorf("Authenticate(%q) = %q, want %q", tt.header, got, tt.user)
+			}
+		})
+	}
+}
+
+func testSpec() map[string]string {
+	return map[string]string{
+		"SourceClusterName":      "gitea",
+		"SourceProfile":          "development",
+		"DestinationProfile":     "production",
+		"DestinationAccountID":   "123456789012",
+		"MigrationKeyAlias":      "alias/migration",
+		"DestinationKMSKeyAlias": "alias/rds",
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+
+	with := func(k, v string) map[string]string {
+		spec := testSpec()
+		spec[k] = v
+		return spec
+	}
+	without := func(k string) map[string]string {
+		spec := testSpec()
+		delete(spec, k)
+		return spec
+	}
+
+	tests := []struct {
+		name string
+		spec map[string]string
+		err  string
+	}{
+		{"valid", testSpec(), ""},
+		{"optional flags", with("SnapshotTTL", "24h"), ""},
+		{"missing required", without("DestinationAccountID"), "DestinationAccountID is required"},
+		{"empty required", with("SourceClusterName", ""), "SourceClusterName is required"},
+		{"unknown", with("Region", "eu-west-1"), "unknown migration parameter Region"},
+		{"reserved", with("AuditLog", "/tmp/audit.log"), "AuditLog is set by the server"},
+		{"UI", with("UI", "true"), "UI is set by the server"},
+		{"invalid duration", with("SnapshotTTL", "3 days"), "invalid value for SnapshotTTL"},
+		{"invalid number", with("MinCapacity", "two"), "invalid value for MinCapacity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateSpec(tt.spec)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("no error, want %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateSpecLeavesFlagsAlone(t *testing.T) {
+
+	before := SourceClusterName
+
+	c, err := ValidateSpec(withFlag(testSpec(), "SnapshotTTL", "24h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SourceClusterName != before {
+		t.Errorf("SourceClusterName = %q, want %q", SourceClusterName, before)
+	}
+
+	// Only the flags of the spec, normalised by the flag package
+	want := []string{
+		"--DestinationAccountID=123456789012",
+		"--DestinationKMSKeyAlias=alias/rds",
+		"--DestinationProfile=production",
+		"--MigrationKeyAlias=alias/migration",
+		"--SnapshotTTL=24h0m0s",
+		"--SourceClusterName=gitea",
+		"--SourceProfile=development",
+	}
+	if got := c.Args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Args() = %q, want %q", got, want)
+	}
+}
+
+func withFlag(spec map[string]string, k, v string) map[string]string {
+	spec[k] = v
+	return spec
+}
+
+// newTestServer runs the server with runs that exit at once.
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command(os.Args[0], "-test.run=^$")
+	}
+
+	s, err := NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.tokens = map[string]string{"alice": "a1"}
+	ts := httptest.NewServer(s)
+
+	t.Cleanup(func() {
+		ts.Close()
+		s.wg.Wait()
+		s.db.Close()
+		execCommand = exec.Command
+	})
+
+	return s, ts
+}
+
+func doRequest(t *testing.T, method, url, token string, body interface{}) *http.Response {
+	t.Helper()
+
+	var b bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&b).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := http.NewRequest(method, url, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestServerHandlers(t *testing.T) {
+
+	_, ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   interface{}
+		code   int
+	}{
+		{"no token", http.MethodGet, "/runs", "", nil, http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/runs", "b2", nil, http.StatusUnauthorized},
+		{"list", http.MethodGet, "/runs", "a1", nil, http.StatusOK},
+		{"unknown path", http.MethodGet, "/jobs", "a1", nil, http.StatusNotFound},
+		{"unknown run", http.MethodGet, "/runs/20220101000000-00000000", "a1", nil, http.StatusNotFound},
+		{"log of unknown run", http.MethodGet, "/runs/20220101000000-00000000/log", "a1", nil, http.StatusNotFound},
+		{"cancel unknown run", http.MethodPost, "/runs/20220101000000-00000000/cancel", "a1", nil, http.StatusConflict},
+		{"invalid spec", http.MethodPost, "/runs", "a1", map[string]string{"Region": "eu-west-1"}, http.StatusBadRequest},
+		{"submit", http.MethodPost, "/runs", "a1", testSpec(), http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doRequest(t, tt.method, ts.URL+tt.path, tt.token, tt.body)
+			if resp.StatusCode != tt.code {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.code)
+			}
+		})
+	}
+}
+
+// Run with -race: the runs finish while their submission is answered.
+func TestServerSubmit(t *testing.T) {
+
+	s, ts := newTestServer(t)
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		ids []string
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r, err := http.NewRequest(http.MethodPost, ts.URL+"/runs", strings.NewReader(`{"SourceClusterName": "gitea", "SourceProfile": "development", "DestinationProfile": "production", "DestinationAccountID": "123456789012", "MigrationKeyAlias": "alias/migration", "DestinationKMSKeyAlias": "alias/rds"}`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			r.Header.Set("Authorization", "Bearer a1")
+			r.Header.Set("X-Forwarded-User", "mallory")
+			resp, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+
+			var run Run
+			if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+				t.Error(err)
+				return
+			}
+			if resp.StatusCode != http.StatusCreated || run.Status != RunRunning {
+				t.Errorf("submit = %d %s, want %d %s", resp.StatusCode, run.Status, http.StatusCreated, RunRunning)
+			}
+			if run.SubmittedBy != "alice" || run.ForwardedUser != "mallory" {
+				t.Errorf("submitted by %q, forwarded user %q", run.SubmittedBy, run.ForwardedUser)
+			}
+			mu.Lock()
+			ids = append(ids, run.ID)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	s.wg.Wait()
+
+	for _, id := range ids {
+		run, err := s.get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run == nil || run.Status != RunSucceeded || run.FinishedAt == nil {
+			t.Errorf("run %s = %+v, want %s", id, run, RunSucceeded)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package dbmigration
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+// DestinationClusterEngineMode accepts "serverlessv2" on top of the RDS
+// engine modes. Serverless v2 clusters are "provisioned" clusters with a
+// v2 scaling configuration and db.serverless instances.
+const (
+	EngineModeServerless    = "serverless"
+	EngineModeServerlessV2  = "serverlessv2"
+	ServerlessInstanceClass = "db.serverless"
+)
+
+// Capacity is in Aurora capacity units. Serverless v1 only takes whole
+// units, Serverless v2 takes steps of 0.5. Auto pause and the timeout
+// action only apply to Serverless v1.
+var (
+	MinCapacity           float64
+	MaxCapacity           float64
+	AutoPause                   = true
+	SecondsUntilAutoPause int64 = 300
+	TimeoutAction               = "RollbackCapacityChange"
+	SecondsBeforeTimeout  int64 = 300
+)
+
+// ValidateScaling checks the capacity settings against the engine mode.
+func ValidateScaling() error {
+
+	switch DestinationClusterEngineMode {
+	case EngineModeServerless:
+		if MinCapacity != math.Trunc(MinCapacity) || MaxCapacity != math.Trunc(MaxCapacity) {
+			return errors.New("MinCapacity and MaxCapacity must be whole capacity units for serverless clusters")
+		}
+		if TimeoutAction != "RollbackCapacityChange" && TimeoutAction != "ForceApplyCapacityChange" {
+			return errors.New("TimeoutAction must be RollbackCapacityChange or ForceApplyCapacityChange")
+		}
+	case EngineModeServerlessV2:
+		if MinCapacity == 0 || MaxCapacity == 0 {
+			return errors.New("MinCapacity and MaxCapacity are required for serverlessv2 clusters")
+		}
+		if MinCapacity < 0.5 || MaxCapacity > 128 {
+			return errors.New("serverlessv2 capacity must be between 0.5 and 128")
+		}
+		if math.Mod(MinCapacity*2, 1) != 0 || math.Mod(MaxCapacity*2, 1) != 0 {
+			return errors.New("serverlessv2 capacity must be a multiple of 0.5")
+		}
+	default:
+		if MinCapacity != 0 || MaxCapacity != 0 {
+			return errors.New("MinCapacity and MaxCapacity only apply to serverless and serverlessv2 clusters")
+		}
+		return nil
+	}
+
+	if MaxCapacity != 0 && MinCapacity > MaxCapacity {
+		return errors.New("MinCapacity " + strconv.FormatFloat(MinCapacity, 'f', -1, 64) + " is greater than MaxCapacity " + strconv.FormatFloat(MaxCapacity, 'f', -1, 64))
+	}
+
+	return nil
+}
+
+// ApplyServerlessDefaults switches the instance types left at their
+// provisioned defaults to db.serverless for Serverless v2 clusters.
+func ApplyServerlessDefaults() {
+
+	if DestinationClusterEngineMode != EngineModeServerlessV2 {
+		return
+	}
+	if DestinationWriterInstanceType == DefaultWriterInstanceType {
+		DestinationWriterInstanceType = ServerlessInstanceClass
+	}
+	if DestinationReaderInstanceType == DefaultReaderInstanceType {
+		DestinationReaderInstanceType = ServerlessInstanceClass
+	}
+}
+
+// EngineMode is the engine mode sent to RDS.
+func EngineMode() string {
+	if DestinationClusterEngineMode == EngineModeServerlessV2 {
+		return "provisioned"
+	}
+	return DestinationClusterEngineMode
+}
+
+func ScalingConfiguration() *rds.ScalingConfiguration {
+
+	if DestinationClusterEngineMode != EngineModeServerless {
+		return nil
+	}
+
+	c := &rds.ScalingConfiguration{
+		AutoPause:            aws.Bool(AutoPause),
+		TimeoutAction:        aws.String(TimeoutAction),
+		SecondsBeforeTimeout: aws.Int64(SecondsBeforeTimeout),
+	}
+	if AutoPause {
+		c.SecondsUntilAutoPause = aws.Int64(SecondsUntilAutoPause)
+	}
+	if MinCapacity != 0 {
+		c.MinCapacity = aws.Int64(int64(MinCapacity))
+	}
+	if MaxCapacity != 0 {
+		c.MaxCapacity = aws.Int64(int64(MaxCapacity))
+	}
+
+	return c
+}
+
+func ServerlessV2ScalingConfiguration() *rds.ServerlessV2ScalingConfiguration {
+
+	if DestinationClusterEngineMode != EngineModeServerlessV2 {
+		return nil
+	}
+
+	return &rds.ServerlessV2ScalingConfiguration{
+		MinCapacity: aws.Float64(MinCapacity),
+		MaxCapacity: aws.Float64(MaxCapacity),
+	}
+}
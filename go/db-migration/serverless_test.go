@@ -0,0 +1,43 @@
+package dbmigration
+
+import "testing"
+
+func TestValidateScaling(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		engineMode    string
+		min, max      float64
+		timeoutAction string
+		valid         bool
+	}{
+		{"provisioned", "provisioned", 0, 0, "RollbackCapacityChange", true},
+		{"provisioned with capacity", "provisioned", 2, 8, "RollbackCapacityChange", false},
+		{"serverless", EngineModeServerless, 2, 8, "RollbackCapacityChange", true},
+		{"serverless without capacity", EngineModeServerless, 0, 0, "ForceApplyCapacityChange", true},
+		{"serverless half units", EngineModeServerless, 1.5, 8, "RollbackCapacityChange", false},
+		{"serverless invalid timeout action", EngineModeServerless, 2, 8, "Wait", false},
+		{"serverless min above max", EngineModeServerless, 16, 8, "RollbackCapacityChange", false},
+		{"serverlessv2", EngineModeServerlessV2, 0.5, 128, "RollbackCapacityChange", true},
+		{"serverlessv2 half units", EngineModeServerlessV2, 1.5, 4.5, "RollbackCapacityChange", true},
+		{"serverlessv2 without capacity", EngineModeServerlessV2, 0, 0, "RollbackCapacityChange", false},
+		{"serverlessv2 below 0.5", EngineModeServerlessV2, 0.25, 4, "RollbackCapacityChange", false},
+		{"serverlessv2 above 128", EngineModeServerlessV2, 2, 256, "RollbackCapacityChange", false},
+		{"serverlessv2 quarter units", EngineModeServerlessV2, 1.25, 4, "RollbackCapacityChange", false},
+		{"serverlessv2 min above max", EngineModeServerlessV2, 8, 4, "RollbackCapacityChange", false},
+	}
+
+	mode, min, max, action := DestinationClusterEngineMode, MinCapacity, MaxCapacity, TimeoutAction
+	defer func() {
+		DestinationClusterEngineMode, MinCapacity, MaxCapacity, TimeoutAction = mode, min, max, action
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DestinationClusterEngineMode, MinCapacity, MaxCapacity, TimeoutAction = tt.engineMode, tt.min, tt.max, tt.timeoutAction
+			if err := ValidateScaling(); (err == nil) != tt.valid {
+				t.Errorf("ValidateScaling() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package dbmigration
+
+import (
+	"errors"
+	"flag"
+	"log"
+
+	"awssession"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Each account is reached with its profile and region, optionally
+// assuming a role in it. Endpoint sends every request to a local AWS
+// stand-in instead, it defaults to the AWS_ENDPOINT_URL environment
+// variable.
+var (
+	SourceRoleArn         string
+	SourceExternalID      string
+	DestinationRoleArn    string
+	DestinationExternalID string
+	Endpoint              string
+)
+
+// SessionFlags registers the role and endpoint flags shared by every
+// command, next to their own profile and region flags.
+func SessionFlags(fs *flag.FlagSet) {
+	fs.StringVar(&SourceRoleArn, "SourceRoleArn", SourceRoleArn, "A role to assume in the source account with the credentials of SourceProfile")
+	fs.StringVar(&SourceExternalID, "SourceExternalID", SourceExternalID, "The external ID required to assume SourceRoleArn")
+	fs.StringVar(&DestinationRoleArn, "DestinationRoleArn", DestinationRoleArn, "A role to assume in the destination account with the credentials of DestinationProfile")
+	fs.StringVar(&DestinationExternalID, "DestinationExternalID", DestinationExternalID, "The external ID required to assume DestinationRoleArn")
+	fs.StringVar(&Endpoint, "Endpoint", Endpoint, "Send every AWS request to this endpoint, e.g. a local AWS stand-in")
+}
+
+func SourceConfig() awssession.Config {
+	return awssession.Config{
+		Profile:    SourceProfile,
+		Region:     SourceProfileRegion,
+		RoleArn:    SourceRoleArn,
+		ExternalID: SourceExternalID,
+		Endpoint:   Endpoint,
+	}
+}
+
+func DestinationConfig() awssession.Config {
+	return awssession.Config{
+		Profile:    DestinationProfile,
+		Region:     DestinationProfileRegion,
+		RoleArn:    DestinationRoleArn,
+		ExternalID: DestinationExternalID,
+		Endpoint:   Endpoint,
+	}
+}
+
+// NewSession creates the session of an account with its mutations
+// recorded in the audit log, which can't be signed without its key.
+func NewSession(c awssession.Config) *session.Session {
+
+	if err := CheckAuditKey(); err != nil {
+		log.Fatal(err)
+	}
+
+	sess, err := awssession.New(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	AttachAudit(sess)
+
+	return sess
+}
+
+// CheckAccount fails unless the credentials of the session belong to the
+// account. DestinationAccountID is only given, the snapshot is shared with
+// it and the decommission token issued for it, so it has to be the
+// account of the destination profile.
+func CheckAccount(name, account string, sess *session.Session) error {
+
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return errors.New("Unable to check " + name + ": " + err.Error())
+	}
+
+	if actual := aws.StringValue(identity.Account); actual != account {
+		return errors.New(name + " " + account + " is not the account of the destination credentials, " + aws.StringValue(identity.Arn) + " is in " + actual)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package dbmigration
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// By default the migration starts from a fresh snapshot of the source
+// cluster. FromSnapshot reuses an existing manual or automated snapshot
+// instead, RestoreTime restores the source cluster as it was at that
+// time into a temporary cluster and snapshots that one.
+var (
+	FromSnapshot string
+	RestoreTime  string
+
+	TemporaryClusterName = "migrationtemp-" + RunID
+)
+
+// ValidateSource checks the source selection flags before anything is created.
+func ValidateSource() (time.Time, error) {
+
+	var rt time.Time
+
+	if FromSnapshot != "" && RestoreTime != "" {
+		return rt, errors.New("FromSnapshot and RestoreTime can't be used together")
+	}
+	if RestoreTime == "" {
+		return rt, nil
+	}
+
+	rt, err := time.Parse(time.RFC3339, RestoreTime)
+	if err != nil {
+		return rt, errors.New("RestoreTime must be in RFC3339 format, e.g. 2022-05-01T13:00:00Z: " + err.Error())
+	}
+
+	return rt, nil
+}
+
+// PrepareSourceSnapshot returns the identifier of the snapshot the
+// migration copies with the migration key. The second value is false when
+// the snapshot existed before and must not be deleted.
+func PrepareSourceSnapshot(rt time.Time, expires time.Time, sess *session.Session) (string, bool) {
+
+	if FromSnapshot != "" {
+		Log("Using existing snapshot: " + FromSnapshot)
+		result, err := GetClusterSnapshot(FromSnapshot, "any", sess)
+		if err != nil {
+			Fatal(err)
+		}
+		if len(result.DBClusterSnapshots) == 0 {
+			Fatal("Snapshot " + FromSnapshot + " not found")
+		}
+		if *result.DBClusterSnapshots[0].Status != "available" {
+			Fatal("Snapshot " + FromSnapshot + " is " + *result.DBClusterSnapshots[0].Status + ", expected available")
+		}
+		return FromSnapshot, false
+	}
+
+	snapshotCluster := SourceClusterName
+
+	if !rt.IsZero() {
+		source, err := GetCluster(SourceClusterName, sess)
+		if err != nil {
+			Fatal(err)
+		}
+		c := source.DBClusters[0]
+		if c.EarliestRestorableTime != nil && rt.Before(*c.EarliestRestorableTime) {
+			Fatal("RestoreTime " + RestoreTime + " is before the earliest restorable time " + c.EarliestRestorableTime.UTC().Format(time.RFC3339))
+		}
+		if c.LatestRestorableTime != nil && rt.After(*c.LatestRestorableTime) {
+			Fatal("RestoreTime " + RestoreTime + " is after the latest restorable time " + c.LatestRestorableTime.UTC().Format(time.RFC3339))
+		}
+
+		Log("Restoring " + SourceClusterName + " as of " + RestoreTime + " into temporary cluster " + TemporaryClusterName)
+		_, err = RestoreClusterToPointInTime(c, TemporaryClusterName, rt, MigrationTags(expires), sess)
+		if err != nil {
+			Fatal(err)
+		}
+		AddTemporaryCluster(TemporaryClusterName, sess)
+
+		Log("Wait until temporary cluster is ready...")
+		for status := false; !status; {
+			Sleep(1 * time.Minute)
+			result, err := GetCluster(TemporaryClusterName, sess)
+			if err != nil {
+				Fatal(err)
+			}
+			Progress(TemporaryClusterName, *result.DBClusters[0].Status, -1)
+			if *result.DBClusters[0].Status == "available" {
+				status = true
+			}
+		}
+		snapshotCluster = TemporaryClusterName
+	}
+
+	Log("Creating db cluster snapshot: " + ClusterSnapshotName)
+	_, err := CreateClusterSnapshot(snapshotCluster, ClusterSnapshotName, MigrationTags(expires), sess)
+	if err != nil {
+		Fatal(err)
+	}
+	AddTemporarySnapshot(ClusterSnapshotName, sess)
+	Log("Wait until Snapshot is completed...")
+	for status := false; !status; {
+		Sleep(1 * time.Minute)
+		result, err := GetClusterSnapshot(ClusterSnapshotName, "", sess)
+		if err != nil {
+			Fatal(err)
+		}
+		Progress(ClusterSnapshotName, *result.DBClusterSnapshots[0].Status, aws.Int64Value(result.DBClusterSnapshots[0].PercentProgress))
+		if *result.DBClusterSnapshots[0].Status == "available" {
+			status = true
+		}
+	}
+	Log("Cluster snapshot successfully created")
+
+	if !rt.IsZero() {
+		Log("Deleting temporary cluster: " + TemporaryClusterName)
+		_, err := RemoveCluster(TemporaryClusterName, sess)
+		if err != nil {
+			Fatal(err)
+		}
+		RemoveTemporaryCluster(TemporaryClusterName)
+	}
+
+	return ClusterSnapshotName, true
+}
@@ -0,0 +1,157 @@
+package dbmigration
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"awssession"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds"
+)
+
+// Tags put on every resource created by db-migration. The expiry tag is
+// what the gc command looks at.
+const (
+	TagRunID         = "migration-run-id"
+	TagSourceCluster = "migration-source-cluster"
+	TagOwner         = "migration-owner"
+	TagExpiresAt     = "migration-expires-at"
+)
+
+var (
+	Owner       = os.Getenv("USER")
+	SnapshotTTL = 72 * time.Hour
+	DryRun      bool
+)
+
+// NewRunID returns a sortable, collision-free identifier: a UTC timestamp
+// followed by random bytes so two runs started in the same second differ.
+func NewRunID() string {
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+
+	return time.Now().UTC().Format("20060102150405") + "-" + hex.EncodeToString(b)
+}
+
+// runID lets the server pass the ID of the run it started, so the
+// resources are tagged with the same ID the API reports.
+func runID() string {
+	if id := os.Getenv("DB_MIGRATION_RUN_ID"); id != "" {
+		return id
+	}
+	return NewRunID()
+}
+
+// MigrationTags returns the tags for a resource created by the current run.
+// Only temporary resources get an expiry, the migrated cluster and its
+// instances are meant to stay.
+func MigrationTags(expires time.Time) []*rds.Tag {
+
+	tags := []*rds.Tag{
+		{Key: aws.String(TagRunID), Value: aws.String(RunID)},
+		{Key: aws.String(TagSourceCluster), Value: aws.String(SourceClusterName)},
+		{Key: aws.String(TagOwner), Value: aws.String(Owner)},
+	}
+	if !expires.IsZero() {
+		tags = append(tags, &rds.Tag{Key: aws.String(TagExpiresAt), Value: aws.String(expires.UTC().Format(time.RFC3339))})
+	}
+
+	return tags
+}
+
+// expiredSnapshots returns the manual snapshots whose expiry tag is in the past.
+func expiredSnapshots(sess *session.Session) ([]*rds.DBClusterSnapshot, error) {
+
+	var expired []*rds.DBClusterSnapshot
+
+	for marker := ""; ; {
+		result, err := GetManualClusterSnapshots(marker, sess)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, s := range result.DBClusterSnapshots {
+			for _, t := range s.TagList {
+				if aws.StringValue(t.Key) != TagExpiresAt {
+					continue
+				}
+				expires, err := time.Parse(time.RFC3339, aws.StringValue(t.Value))
+				if err != nil {
+					log.Println("Snapshot", *s.DBClusterSnapshotIdentifier, "has an invalid expiry tag:", *t.Value)
+					continue
+				}
+				if time.Now().After(expires) {
+					expired = append(expired, s)
+				}
+			}
+		}
+
+		if result.Marker == nil {
+			return expired, nil
+		}
+		marker = *result.Marker
+	}
+}
+
+// GC is the gc command, it deletes expired migration snapshots in both
+// the source and the destination account.
+func GC(args []string) {
+
+	AuditCommand = "gc"
+
+	fs := flag.NewFlagSet(AuditCommand, flag.ExitOnError)
+	fs.StringVar(&SourceProfile, "SourceProfile", SourceProfile, "The name of the profile with access to the source db cluster account")
+	fs.StringVar(&SourceProfileRegion, "SourceProfileRegion", SourceProfileRegion, "Specify the region where the source db is located.")
+	fs.StringVar(&DestinationProfile, "DestinationProfile", DestinationProfile, "The name of the profile with access to the destination db cluster account")
+	fs.StringVar(&DestinationProfileRegion, "DestinationProfileRegion", DestinationProfileRegion, "Specify the region where the db was migrated.")
+	SessionFlags(fs)
+	fs.BoolVar(&DryRun, "DryRun", DryRun, "Only list the expired snapshots without deleting them")
+	fs.StringVar(&AuditLogPath, "AuditLog", AuditLogPath, "The file where every AWS mutation call is recorded")
+	if _, err := LoadConfig(fs, args, false); err != nil {
+		log.Fatal(err)
+	}
+
+	accounts := []struct {
+		name   string
+		config awssession.Config
+	}{
+		{"source", SourceConfig()},
+		{"destination", DestinationConfig()},
+	}
+
+	var count int
+	for _, a := range accounts {
+		sess := NewSession(a.config)
+
+		snapshots, err := expiredSnapshots(sess)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, s := range snapshots {
+			if DryRun {
+				Log("Would delete expired snapshot in " + a.name + " account: " + *s.DBClusterSnapshotIdentifier)
+				continue
+			}
+			Log("Deleting expired snapshot in " + a.name + " account: " + *s.DBClusterSnapshotIdentifier)
+			_, err := RemoveClusterSnapshot(*s.DBClusterSnapshotIdentifier, sess)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			count++
+		}
+	}
+
+	Log("Expired migration snapshots deleted: " + strconv.Itoa(count))
+}
@@ -0,0 +1,292 @@
+package dbmigration
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// UI enables the terminal UI. When stdout isn't a terminal the migration
+// falls back to plain logs.
+var UI bool
+
+type phase struct {
+	name       string
+	start, end time.Time
+}
+
+type resourceStatus struct {
+	status  string
+	percent int64
+	since   time.Time
+}
+
+type terminalUI struct {
+	mu        sync.Mutex
+	start     time.Time
+	phases    []phase
+	current   int
+	resources map[string]*resourceStatus
+	order     []string
+	logs      []string
+	state     *term.State
+	stop      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+var (
+	// ui is only read and set under uiMu, through currentUI and StopUI,
+	// since the UI is stopped from any goroutine
+	ui   *terminalUI
+	uiMu sync.Mutex
+
+	// last reported status per resource, used in plain log mode to only
+	// print changes
+	plainMu     sync.Mutex
+	plainStatus = map[string]string{}
+)
+
+// StartUI draws the terminal UI with the given phases. It returns false
+// when the UI is disabled or stdout isn't a terminal.
+func StartUI(phases []string) bool {
+
+	if !UI || !term.IsTerminal(int(os.Stdout.Fd())) || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		log.Println("Unable to start the terminal UI:", err)
+		return false
+	}
+
+	t := &terminalUI{
+		start:     time.Now(),
+		current:   -1,
+		resources: map[string]*resourceStatus{},
+		state:     state,
+		stop:      make(chan struct{}),
+	}
+	for _, p := range phases {
+		t.phases = append(t.phases, phase{name: p})
+	}
+
+	// Anything written with the log package ends up in the log pane
+	log.SetOutput(t)
+	log.SetFlags(log.Ltime)
+	uiMu.Lock()
+	ui = t
+	uiMu.Unlock()
+
+	t.stopped.Add(1)
+	go t.loop()
+	go t.keys()
+
+	return true
+}
+
+// currentUI returns the running UI, nil when there is none. Callers keep
+// using what it returned even if the UI is stopped meanwhile.
+func currentUI() *terminalUI {
+	uiMu.Lock()
+	defer uiMu.Unlock()
+	return ui
+}
+
+// StopUI restores the terminal. It is safe to call when the UI isn't
+// running, and from several goroutines: the first call stops it and the
+// others wait until the terminal is restored, so that nobody exits before.
+func StopUI() {
+
+	uiMu.Lock()
+	defer uiMu.Unlock()
+
+	t := ui
+	if t == nil {
+		return
+	}
+	ui = nil
+
+	close(t.stop)
+	t.stopped.Wait()
+	term.Restore(int(os.Stdin.Fd()), t.state)
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+
+	t.mu.Lock()
+	for _, l := range t.logs {
+		fmt.Fprintln(os.Stderr, l)
+	}
+	t.mu.Unlock()
+}
+
+// SetPhase marks the previous phase as done and starts the given one.
+func SetPhase(i int) {
+	ui := currentUI()
+	if ui == nil {
+		return
+	}
+
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	now := time.Now()
+	if ui.current >= 0 {
+		ui.phases[ui.current].end = now
+	}
+	ui.current = i
+	if i < len(ui.phases) {
+		ui.phases[i].start = now
+	}
+	ui.resources = map[string]*resourceStatus{}
+	ui.order = nil
+}
+
+// Progress reports the AWS status of a resource in the current phase.
+// Percent is -1 when AWS doesn't report any progress.
+func Progress(resource, status string, percent int64) {
+
+	ui := currentUI()
+	if ui == nil {
+		line := resource + ": " + status
+		if percent >= 0 {
+			line += fmt.Sprintf(" (%d%%)", percent)
+		}
+		plainMu.Lock()
+		defer plainMu.Unlock()
+		if plainStatus[resource] != line {
+			plainStatus[resource] = line
+			log.Println(line)
+		}
+		return
+	}
+
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	r, ok := ui.resources[resource]
+	if !ok {
+		r = &resourceStatus{since: time.Now()}
+		ui.resources[resource] = r
+		ui.order = append(ui.order, resource)
+	}
+	r.status = status
+	r.percent = percent
+}
+
+// Write implements io.Writer for the log package.
+func (t *terminalUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, l := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.logs = append(t.logs, l)
+	}
+	if len(t.logs) > 200 {
+		t.logs = t.logs[len(t.logs)-200:]
+	}
+
+	return len(p), nil
+}
+
+func (t *terminalUI) keys() {
+	b := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(b); err != nil {
+			return
+		}
+		switch b[0] {
+		case 'q', 'a', 3: // 3 is Ctrl-C in raw mode
+			log.Println("Abort requested")
+			AbortMigration()
+		}
+	}
+}
+
+func (t *terminalUI) loop() {
+	defer t.stopped.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		t.render(os.Stdout)
+		select {
+		case <-ticker.C:
+		case <-t.stop:
+			t.render(os.Stdout)
+			return
+		}
+	}
+}
+
+func (t *terminalUI) render(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	now := time.Now()
+
+	// Raw mode doesn't translate \n so every line ends with \r\n
+	b.WriteString("\033[H\033[J")
+	fmt.Fprintf(&b, "db-migration  run %s  elapsed %s\r\n\r\n", RunID, now.Sub(t.start).Round(time.Second))
+
+	for i, p := range t.phases {
+		switch {
+		case i < t.current && p.start.IsZero():
+			fmt.Fprintf(&b, " [-] %-32s skipped\r\n", p.name)
+		case i < t.current:
+			fmt.Fprintf(&b, " [x] %-32s %s\r\n", p.name, p.end.Sub(p.start).Round(time.Second))
+		case i == t.current:
+			fmt.Fprintf(&b, " [>] %-32s %s\r\n", p.name, now.Sub(p.start).Round(time.Second))
+		default:
+			fmt.Fprintf(&b, " [ ] %s\r\n", p.name)
+		}
+	}
+	b.WriteString("\r\n")
+
+	for _, name := range t.order {
+		r := t.resources[name]
+		fmt.Fprintf(&b, " %-40s %-12s", name, r.status)
+		if r.percent >= 0 {
+			fmt.Fprintf(&b, " %s %3d%%  ETA %s", bar(r.percent, 30), r.percent, eta(now.Sub(r.since), r.percent))
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+
+	logs := t.logs
+	if len(logs) > 10 {
+		logs = logs[len(logs)-10:]
+	}
+	for _, l := range logs {
+		b.WriteString(" " + l + "\r\n")
+	}
+
+	b.WriteString("\r\n q: abort and clean up temporary resources\r\n")
+
+	io.WriteString(w, b.String())
+}
+
+func bar(percent int64, width int) string {
+	filled := int(percent) * width / 100
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// eta extrapolates the remaining time from the progress made so far.
+func eta(elapsed time.Duration, percent int64) string {
+	if percent <= 0 || percent >= 100 {
+		return "-"
+	}
+	remaining := time.Duration(float64(elapsed) * float64(100-percent) / float64(percent))
+	return remaining.Round(time.Second).String()
+}
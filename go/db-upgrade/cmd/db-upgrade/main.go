@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	dbupgrade "db-upgrade"
+)
+
+func main() {
+	dbupgrade.Main(os.Args[1:])
+}
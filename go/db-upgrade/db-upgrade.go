@@ -1,10 +1,9 @@
-package main
+package dbupgrade
 
 import (
-
   "fmt"
 )
 
-func main() {
+func Main(args []string) {
   fmt.Println("db-upgrade")
 }
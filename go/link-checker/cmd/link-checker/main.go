@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	linkchecker "link-checker"
+)
+
+func main() {
+	linkchecker.Main(os.Args[1:])
+}
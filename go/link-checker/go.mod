@@ -3,8 +3,11 @@ module link-checker
 go 1.18
 
 require (
+	awssession v0.0.0-00010101000000-000000000000
 	github.com/aws/aws-lambda-go v1.31.1
 	github.com/aws/aws-sdk-go v1.44.14
 )
 
 require github.com/jmespath/go-jmespath v0.4.0 // indirect
+
+replace awssession => ../awssession
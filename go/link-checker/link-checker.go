@@ -1,4 +1,4 @@
-package main
+package linkchecker
 
 import (
 	"errors"
@@ -8,6 +8,8 @@ import (
 	"os"
 	"sync"
 
+	"awssession"
+
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -16,18 +18,57 @@ import (
 
 var topic = "arn:aws:sns:eu-west-2:075107581003:tower-monitoring"
 
-func main() {
-	lambda.Start(Handler)
+// The links to check, the arguments of the program
+var links []string
+
+// Main checks the links given as arguments, as a Lambda function or, out
+// of Lambda, once, exiting with 1 when a link is down.
+func Main(args []string) {
+
+	links = args
+
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(Handler)
+		return
+	}
+
+	down, err := Check()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if down > 0 {
+		os.Exit(1)
+	}
 }
 
-func Handler() {
+// Handler checks the links from Lambda, the invocation fails when a link
+// is down so the function's Errors metric can be alarmed on.
+func Handler() error {
 
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+	down, err := Check()
+	if err != nil {
+		return err
+	}
+	if down > 0 {
+		return fmt.Errorf("%d of %d links are down", down, len(links))
+	}
 
-	var wg sync.WaitGroup
-	links := os.Args[1:]
+	return nil
+}
+
+// Check notifies the links that are down and returns how many are.
+func Check() (int, error) {
+
+	sess, err := awssession.New(awssession.Config{})
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		down int
+	)
 
 	wg.Add(len(links))
 
@@ -35,6 +76,9 @@ func Handler() {
 		go func(link string, wg *sync.WaitGroup) {
 			_, err := GetLink(link)
 			if err != nil {
+				mu.Lock()
+				down++
+				mu.Unlock()
 				PublishSNSNotification(topic, fmt.Sprint(err), sess)
 			}
 			defer wg.Done()
@@ -42,6 +86,8 @@ func Handler() {
 	}
 
 	wg.Wait()
+
+	return down, nil
 }
 
 func GetLink(l string) (*http.Response, error) {
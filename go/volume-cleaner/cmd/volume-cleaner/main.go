@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	volumecleaner "volume-cleaner"
+)
+
+func main() {
+	volumecleaner.Main(os.Args[1:])
+}
@@ -1,10 +1,13 @@
-package main
+package volumecleaner
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 
+	"awssession"
+
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -81,54 +84,84 @@ func DeleteVolume(v string, sess *session.Session) (*ec2.DeleteVolumeOutput, err
 	return result, nil
 }
 
-// The handler function is the funtion used by lambda to call the main code
-// All the code logic is inside of this function
-func Handler() {
+// With DryRun the orphan volumes are listed without deleting them
+var DryRun bool
+
+// Main deletes the orphan volumes, as a Lambda function or, out of
+// Lambda, once, exiting with 1 on errors.
+func Main(args []string) {
+
+	fs := flag.NewFlagSet("volume-cleaner", flag.ExitOnError)
+	fs.BoolVar(&DryRun, "dry-run", DryRun, "List the orphan volumes without deleting them")
+	fs.Parse(args)
+
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		// invoking habdle function from lambda
+		lambda.Start(Handler)
+		return
+	}
+
+	if err := Clean(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// The handler function is the funtion used by lambda to call the main code,
+// the invocation fails when the cleanup does
+func Handler() error {
+	return Clean()
+}
+
+// Clean deletes the orphan volumes, errors are printed and returned
+func Clean() error {
 
 	var (
-		sess            *session.Session
-		err             error
-		count           int
-		volumes         *ec2.DescribeVolumesOutput
-		region, profile string
+		sess    *session.Session
+		err     error
+		count   int
+		volumes *ec2.DescribeVolumesOutput
 	)
 
-	// Craeting a session using the Must function
-	// this ensure the session will be working before creating it
-	sess = session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Profile:           profile,
-		Config: aws.Config{
-			Region: aws.String(region),
-		},
-	}))
+	// Creating the session from the environment of the function,
+	// the profile and region come from the AWS_ variables
+	sess, err = awssession.New(awssession.Config{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
 
 	// Get the volumes
 	volumes, err = GetVolumes(sess)
 	switch {
 	case err != nil:
 		fmt.Fprintln(os.Stderr, err)
-		return
+		return err
 	case len(volumes.Volumes) == 0:
 		fmt.Println("0 orphan volumes found!")
-		return
+		return nil
 	}
 
 	// loop through the volumes to delete them
 	for _, v := range volumes.Volumes {
+		if DryRun {
+			fmt.Println("Would delete orphan volume: " + *v.VolumeId)
+			count++
+			continue
+		}
 		fmt.Println("Deleting orphan volume: " + *v.VolumeId)
 		_, err := DeleteVolume(*v.VolumeId, sess)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error deleting orphan volume: %v\n%v ", *v.VolumeId, err)
-			return
+			return err
 		}
 		count++
 	}
 
+	if DryRun {
+		fmt.Printf("%v volumes would be deleted\n", count)
+		return nil
+	}
 	fmt.Printf("%v volemes has been deleted!\n", count)
-}
 
-func main() {
-	// invoking habdle function from lambda
-	lambda.Start(Handler)
+	return nil
 }